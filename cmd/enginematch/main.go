@@ -0,0 +1,132 @@
+// Command enginematch plays engines against each other and reports the
+// score and search speed of each side, e.g.
+//
+//	go run ./cmd/enginematch -a mcts-hard:workers=1 -b mcts-hard -games 20 -time 2s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"wuziqi/src"
+	"wuziqi/src/rules"
+)
+
+// player wraps an engine to total the playouts and thinking time of its
+// searches.
+type player struct {
+	src.Engine
+	spec     string
+	playouts int
+	thinking time.Duration
+	wins     int
+}
+
+func (p *player) GenMove(ctx context.Context) (int, int, error) {
+	start := time.Now()
+	row, col, err := p.Engine.GenMove(ctx)
+	p.thinking += time.Since(start)
+	if r, ok := p.Engine.(src.StatsReporter); ok {
+		p.playouts += r.Stats().Playouts
+	}
+	return row, col, err
+}
+
+// newPlayer builds an engine from a spec of the form name:opt=value,...
+func newPlayer(spec string) (*player, error) {
+	name, opts, _ := strings.Cut(spec, ":")
+	e, err := src.NewEngine(name)
+	if err != nil {
+		return nil, err
+	}
+	if opts != "" {
+		for _, opt := range strings.Split(opts, ",") {
+			k, v, ok := strings.Cut(opt, "=")
+			if !ok {
+				e.Close()
+				return nil, fmt.Errorf("option %q is not name=value", opt)
+			}
+			if err := e.SetOption(k, v); err != nil {
+				e.Close()
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return &player{Engine: e, spec: spec}, nil
+}
+
+func main() {
+	specA := flag.String("a", "mcts-hard:workers=1", "first engine, as name:opt=value,...")
+	specB := flag.String("b", "mcts-hard", "second engine, as name:opt=value,...")
+	games := flag.Int("games", 10, "number of games; colours alternate")
+	budget := flag.Duration("time", 2*time.Second, "thinking time per move")
+	size := flag.Int("size", rules.Standard.Size, "board size")
+	winLength := flag.Int("win", rules.Standard.WinLength, "stones in a row to win")
+	ruleName := flag.String("rule", rules.Standard.Rule.String(), "rule set: freestyle, renju, standard, pente, keryo, connect6 or caro")
+	flag.Parse()
+
+	rule, err := rules.ParseRule(*ruleName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	variant := rules.Variant{Size: *size, WinLength: *winLength, Rule: rule}
+	if err := variant.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	a, err := newPlayer(*specA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.Close()
+	b, err := newPlayer(*specB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+
+	draws := 0
+	for i := 0; i < *games; i++ {
+		black, white := a, b
+		if i%2 == 1 {
+			black, white = b, a
+		}
+		winner, err := src.PlayMatch(black, white, variant, *budget)
+		if err != nil {
+			log.Fatalf("game %d: %v", i+1, err)
+		}
+		switch winner {
+		case src.Black:
+			black.wins++
+		case src.White:
+			white.wins++
+		default:
+			draws++
+		}
+		fmt.Printf("game %d: %s (black) vs %s (white): %s\n", i+1, black.spec, white.spec, result(winner))
+	}
+
+	fmt.Println()
+	for _, p := range []*player{a, b} {
+		score := (float64(p.wins) + float64(draws)/2) / float64(*games)
+		speed := ""
+		if p.playouts > 0 {
+			speed = fmt.Sprintf("  %8.0f playouts/s", float64(p.playouts)/p.thinking.Seconds())
+		}
+		fmt.Printf("%-30s score %5.1f%%%s\n", p.spec, score*100, speed)
+	}
+}
+
+func result(winner src.Stone) string {
+	switch winner {
+	case src.Black:
+		return "black wins"
+	case src.White:
+		return "white wins"
+	}
+	return "draw"
+}
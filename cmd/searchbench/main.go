@@ -0,0 +1,47 @@
+// Command searchbench measures MCTS playouts per second for each rollout
+// policy from a fixed opening position, e.g.
+//
+//	go run ./cmd/searchbench -time 5s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"wuziqi/src"
+	"wuziqi/src/rules"
+)
+
+func main() {
+	budget := flag.Duration("time", 3*time.Second, "search time per policy")
+	workers := flag.Int("workers", 1, "search workers; 0 for one per CPU")
+	size := flag.Int("size", rules.Standard.Size, "board size")
+	flag.Parse()
+
+	variant := rules.Variant{Size: *size, WinLength: rules.Standard.WinLength}
+	if err := variant.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	board := src.NewBoard(variant)
+	mid := *size / 2
+	board.Set(mid-1, mid-1, src.Black)
+	board.Set(mid, mid, src.Black)
+	board.Set(mid-1, mid, src.White)
+	board.Set(mid, mid-1, src.White)
+
+	for _, policy := range []src.RolloutPolicy{src.RolloutRandom, src.RolloutLocal, src.RolloutHeuristic} {
+		s := src.NewSearcher(src.SearchProfile{
+			TimeLimit:   *budget,
+			Exploration: 1.0,
+			Rollout:     policy,
+			Workers:     *workers,
+		})
+		start := time.Now()
+		s.Search(context.Background(), board, src.Black)
+		st := s.Stats()
+		fmt.Printf("%-10s %8d playouts  %9.0f playouts/s\n", policy, st.Playouts, float64(st.Playouts)/time.Since(start).Seconds())
+	}
+}
@@ -13,7 +13,9 @@ func main() {
 	ebiten.SetWindowTitle("Gomoku")
 
 	game := src.NewGame()
-	if err := ebiten.RunGame(game); err != nil {
+	err := ebiten.RunGame(game)
+	game.Close()
+	if err != nil {
 		log.Fatal(err)
 	}
 }
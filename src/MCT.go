@@ -2,197 +2,573 @@
 package src
 
 import (
+	"context"
 	"math"
 	"math/rand"
+	"sync"
 	"time"
+
+	"wuziqi/src/rules"
 )
 
-type Board [BoardSize][BoardSize]Stone
+// Board is a position for the searches: the stones, the captures, and the
+// size, line length and rule of the game. It is a value, so a search copies
+// it freely; points past Size stay empty and are never played.
+type Board struct {
+	Size      int
+	WinLength int
+	Rule      rules.Rule
+	cells     [MaxBoardSize][MaxBoardSize]Stone
+	captured  [3]int // stones captured, by the colour that took them
+}
+
+func NewBoard(v rules.Variant) Board {
+	return Board{Size: v.Size, WinLength: v.WinLength, Rule: v.Rule}
+}
+
+// BoardOf copies the stones of pos for the searches.
+func BoardOf(pos *rules.Position) Board {
+	b := NewBoard(pos.Variant())
+	for r := 0; r < b.Size; r++ {
+		for c := 0; c < b.Size; c++ {
+			b.cells[r][c] = pos.At(r, c)
+		}
+	}
+	b.captured[Black], b.captured[White] = pos.Captured(Black), pos.Captured(White)
+	return b
+}
+
+func (b *Board) Variant() rules.Variant {
+	return rules.Variant{Size: b.Size, WinLength: b.WinLength, Rule: b.Rule}
+}
+
+// At returns the stone on row, col, which must be on the board.
+func (b *Board) At(row, col int) Stone {
+	return b.cells[row][col]
+}
+
+func (b *Board) Set(row, col int, s Stone) {
+	b.cells[row][col] = s
+}
+
+// Play puts s on row, col and takes off the stones it captures, and
+// returns those it took. Set alone is enough under rules without captures.
+func (b *Board) Play(row, col int, s Stone) [][2]int {
+	b.cells[row][col] = s
+	if b.Rule.CaptureGoal() == 0 {
+		return nil
+	}
+	taken := b.Rule.Captures(b, row, col, s)
+	for _, t := range taken {
+		b.cells[t[0]][t[1]] = Empty
+	}
+	b.captured[s] += len(taken)
+	return taken
+}
+
+// Stones counts the stones on b.
+func (b *Board) Stones() int {
+	n := 0
+	for r := 0; r < b.Size; r++ {
+		for c := 0; c < b.Size; c++ {
+			if b.cells[r][c] != Empty {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (b *Board) OnBoard(row, col int) bool {
+	return row >= 0 && row < b.Size && col >= 0 && col < b.Size
+}
+
+// Legal reports whether s may play the empty point row, col, which only
+// the Renju restrictions on Black can forbid.
+func (b *Board) Legal(row, col int, s Stone) bool {
+	return !b.Rule.Restricts(s) || !rules.Forbidden(b, row, col)
+}
+
+// legalOnly drops the moves s may not play from moves, in place.
+func legalOnly(b *Board, moves [][2]int, s Stone) [][2]int {
+	if !b.Rule.Restricts(s) {
+		return moves
+	}
+	legal := moves[:0]
+	for _, m := range moves {
+		if b.Legal(m[0], m[1], s) {
+			legal = append(legal, m)
+		}
+	}
+	return legal
+}
 
 func BestMove(board Board, forPlayer Stone, difficulty DifficultyLevel) (int, int) {
+	return SearchMove(context.Background(), board, forPlayer, Profile(difficulty))
+}
+
+// SearchMove runs the MCTS within the limits of profile, or until ctx is
+// done, and returns the most visited move.
+func SearchMove(ctx context.Context, board Board, forPlayer Stone, profile SearchProfile) (int, int) {
+	return NewSearcher(profile).Search(ctx, board, forPlayer)
+}
+
+// SearchStats describes the last search of a Searcher.
+type SearchStats struct {
+	ReusedVisits int // visits already under the root when the search began
+	Playouts     int // playouts run by the search
+	RootVisits   int // visits under the root when the search ended
+	Nodes        int // nodes in the tree when the search ended
+}
+
+// defaultMaxNodes caps the tree when the profile sets no limit.
+const defaultMaxNodes = 1 << 17
+
+// Searcher is an MCTS whose tree is kept between moves. Advance follows the
+// game into the subtree of each move played, so the next search starts with
+// the statistics gathered below that position.
+//
+// The tree is a DAG: positions are looked up by Zobrist key in a
+// transposition table, so move orders that reach the same position share
+// one node. Nodes hold no board; the board is rebuilt from rootBoard on the
+// way down.
+//
+// With more than one worker the tree is searched in parallel: each worker
+// selects a leaf under mu, marks the path with a virtual loss so the others
+// spread out, and scores a new leaf's moves and runs its rollout without
+// holding the lock.
+//
+// Under Connect6 a turn is two plies of the same player, so the tree
+// searches the stones of a turn as a pair and the second stone's search
+// starts from the subtree of the first.
+type Searcher struct {
+	Profile    SearchProfile
+	root       *node
+	rootBoard  Board
+	rootStones int // stones played to reach rootBoard, for Rule.Next
+	table      *TransTable[*node]
+	tableSize  int // the MaxNodes the table was made for
+	nodes      int
+	stats      SearchStats
+	rand       *rand.Rand
+
+	mu sync.Mutex // guards the tree and stats during Search
+}
+
+func NewSearcher(profile SearchProfile) *Searcher {
+	return &Searcher{Profile: profile, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Search returns the most visited move for forPlayer on board.
+func (s *Searcher) Search(ctx context.Context, board Board, forPlayer Stone) (int, int) {
 	if win, _ := checkWin(board); win != 0 {
 		return -1, -1
 	}
-	root := newNode(board, forPlayer)
-
-	// --- CHANGE THE DEADLINE LOGIC ---
-	var thinkingTime time.Duration
-	switch difficulty {
-	case Easy:
-		thinkingTime = 1 * time.Second
-	case Medium:
-		thinkingTime = 3 * time.Second
-	default:
-		thinkingTime = 1 * time.Second // Default fallback
+	if s.table == nil || s.tableSize != s.maxNodes() {
+		s.tableSize = s.maxNodes()
+		s.table = NewTransTable[*node](s.tableSize)
+		s.root = nil
+	}
+	if s.root == nil || s.rootBoard != board || s.root.player != forPlayer {
+		s.Reset()
+		s.rootBoard = board
+		s.rootStones = board.Stones()
+		s.root = s.newNode(positionKey(board.Hash(), forPlayer), forPlayer, 3-forPlayer)
+		s.root.untried = expansionMoves(board, forPlayer, s.Profile.PatternOrder, s.rand)
 	}
-	deadline := time.Now().Add(thinkingTime)
-	// --- END CHANGE ---
+	root := s.root
+	s.stats = SearchStats{ReusedVisits: int(root.visits)}
 
-	for time.Now().Before(deadline) {
-		leaf := selectNode(root)
-		winner := simulate(leaf)
-		backpropagate(leaf, winner)
+	deadline := time.Now().Add(s.Profile.TimeLimit)
+	workers := s.Profile.workerCount()
+	if workers == 1 {
+		s.work(ctx, deadline, s.rand)
+	} else {
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			rng := rand.New(rand.NewSource(s.rand.Int63()))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.work(ctx, deadline, rng)
+			}()
+		}
+		wg.Wait()
 	}
+	s.stats.RootVisits = int(root.visits)
+	s.stats.Nodes = s.nodes
 
-	var best *node
+	var best *edge
 	// In MCTS, the most visited node is the most robust choice.
 	maxVisits := -1.0
-	for _, c := range root.children {
-		if c.visits > maxVisits {
-			maxVisits = c.visits
-			best = c
+	for i, e := range root.children {
+		if e.node.visits > maxVisits {
+			maxVisits = e.node.visits
+			best = &root.children[i]
 		}
 	}
 	if best == nil {
 		// This can happen if no simulations are run or the game is over.
-		return randomMove(board)
+		return randomMove(board, forPlayer, s.rand)
 	}
 	return best.move[0], best.move[1]
 }
 
+// work runs playouts from the root until the budget is spent. rng belongs
+// to the calling worker.
+func (s *Searcher) work(ctx context.Context, deadline time.Time, rng *rand.Rand) {
+	for {
+		s.mu.Lock()
+		if s.Profile.Playouts > 0 && s.stats.Playouts >= s.Profile.Playouts ||
+			!time.Now().Before(deadline) || ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		s.stats.Playouts++
+		path, b, made := s.descend()
+		addVirtualLoss(path)
+		s.mu.Unlock()
+
+		leaf := path[len(path)-1]
+		var moves [][2]int
+		if made {
+			moves = expansionMoves(b, leaf.player, s.Profile.PatternOrder, rng)
+		}
+		winner := simulate(b, leaf.player, s.rootStones+len(path)-1, s.Profile.Rollout, rng)
+
+		s.mu.Lock()
+		if made {
+			leaf.untried = moves
+		}
+		backpropagate(path, winner)
+		s.mu.Unlock()
+	}
+}
+
+// descend walks from the root to the node to run the next playout from,
+// and returns the path with the board at its end, and whether it made that
+// node, which is then left for the caller to give its moves. With ordered
+// moves a node
+// widens progressively, trying its next move only once its visits grow past
+// the square of its children, so the best-scored moves are searched first
+// and deepest. Once the tree holds MaxNodes nodes it stops growing.
+func (s *Searcher) descend() ([]*node, Board, bool) {
+	b := s.rootBoard
+	n := s.root
+	path := []*node{n}
+	full := s.nodes >= s.maxNodes()
+	for {
+		placed := s.rootStones + len(path)
+		if len(n.untried) > 0 && !full &&
+			(!s.Profile.PatternOrder || float64(len(n.children)*len(n.children)) <= n.visits) {
+			m := n.untried[0]
+			n.untried = n.untried[1:]
+			h := b.playHashed(positionKey(n.key, n.player), m[0], m[1], n.player)
+			next := b.Rule.Next(n.player, placed)
+			key := positionKey(h, next)
+			child, ok := s.table.Get(key)
+			if !ok {
+				child = s.newNode(key, next, n.player)
+			}
+			n.children = append(n.children, edge{move: m, node: child})
+			return append(path, child), b, !ok
+		}
+		if len(n.children) == 0 {
+			return path, b, false
+		}
+		e := bestUCTChild(n, s.Profile.Exploration)
+		b.Play(e.move[0], e.move[1], n.player)
+		n = e.node
+		path = append(path, n)
+	}
+}
+
+// newNode adds a node for key to the tree, without its moves: scoring them
+// with expansionMoves is the slow part, which the worker that made the node
+// does outside the lock.
+func (s *Searcher) newNode(key uint64, p, mover Stone) *node {
+	n := &node{key: key, player: p, mover: mover}
+	s.table.Put(key, n)
+	s.nodes++
+	return n
+}
+
+func (s *Searcher) maxNodes() int {
+	if s.Profile.MaxNodes > 0 {
+		return s.Profile.MaxNodes
+	}
+	return defaultMaxNodes
+}
+
+// Advance re-roots the tree on the child reached by playing row, col. If
+// that child was never expanded the tree is dropped. The transposition
+// table is rebuilt from what stays reachable, which frees the rest.
+func (s *Searcher) Advance(row, col int) {
+	if s.root == nil {
+		return
+	}
+	for _, e := range s.root.children {
+		if e.move == [2]int{row, col} {
+			s.rootBoard.Play(row, col, s.root.player)
+			s.rootStones++
+			s.root = e.node
+			s.reindex()
+			return
+		}
+	}
+	s.Reset()
+}
+
+// Reset drops the tree, for a new game or after an undo.
+func (s *Searcher) Reset() {
+	s.root = nil
+	s.nodes = 0
+	if s.table != nil {
+		s.table.Clear()
+	}
+}
+
+// reindex refills the table and node count with the nodes under the root.
+func (s *Searcher) reindex() {
+	s.table.Clear()
+	s.nodes = 0
+	seen := map[*node]bool{}
+	stack := []*node{s.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		s.table.Put(n.key, n)
+		s.nodes++
+		for _, e := range n.children {
+			stack = append(stack, e.node)
+		}
+	}
+}
+
+func (s *Searcher) Stats() SearchStats {
+	return s.stats
+}
+
+// node is a position in the search DAG, identified by its Zobrist key with
+// the side to move. Since a node can have several parents, the move that
+// reaches it lives on the edge. Fields other than key, player and mover
+// are guarded by the searcher's mutex while a search runs. Until a new
+// node has its moves, other workers that reach it take it for a leaf.
 type node struct {
-	board    Board
-	move     [2]int
+	key      uint64
 	player   Stone
-	wins     float64
+	mover    Stone   // who moved into the node; player too mid-turn in Connect6
+	wins     float64 // for mover
 	visits   float64
-	children []*node
-	parent   *node
+	children []edge
 	untried  [][2]int
 }
 
-func newNode(b Board, p Stone) *node {
-	return &node{
-		board:   b,
-		player:  p,
-		untried: legalMoves(b),
-	}
+type edge struct {
+	move [2]int
+	node *node
 }
 
-func selectNode(n *node) *node {
-	for len(n.untried) == 0 && len(n.children) > 0 {
-		n = bestUCTChild(n)
+// simulate plays out the game from b with p to move, placed stones into
+// the game, and returns the winner, or Empty for a draw. For speed the
+// playout does not keep Black off Renju's forbidden points; only the tree
+// does.
+func simulate(b Board, p Stone, placed int, rollout RolloutPolicy, rng *rand.Rand) Stone {
+	if win, over := checkWin(b); over {
+		return win
 	}
-	if len(n.untried) > 0 {
-		return expand(n)
+	if b.Rule.CaptureGoal() > 0 {
+		return capturePlayout(&b, p, rng)
+	}
+	bb := NewBitboard(&b)
+	if rollout == RolloutHeuristic {
+		return heuristicPlayout(&bb, b.Rule, p, placed, rng)
+	}
+	buf := make([][2]int, 0, bb.size*bb.size)
+	for {
+		var moves [][2]int
+		if rollout == RolloutLocal {
+			moves = bb.Near(buf[:0])
+		}
+		if len(moves) == 0 {
+			moves = bb.Empties(buf[:0])
+		}
+		if len(moves) == 0 {
+			return 0
+		}
+		m := moves[rng.Intn(len(moves))]
+		bb.Place(m[0], m[1], p)
+		if bb.WinAt(m[0], m[1], p) {
+			return p
+		}
+		placed++
+		p = b.Rule.Next(p, placed)
 	}
-	return n
 }
 
-func expand(n *node) *node {
-	m := n.untried[0]
-	n.untried = n.untried[1:]
-
-	newBoard := n.board
-	newBoard[m[0]][m[1]] = n.player
-
-	child := &node{
-		board:   newBoard,
-		move:    m,
-		player:  3 - n.player,
-		parent:  n,
-		untried: legalMoves(newBoard),
+// heuristicPlayout finishes the game of rule from bb with p to move, placed
+// stones into the game. Each side completes a winning line when it can,
+// otherwise blocks the opponent's, and otherwise plays a random point next
+// to a stone. Since no move is ever played that wins by accident, the board
+// needs no win check.
+func heuristicPlayout(bb *Bitboard, rule rules.Rule, p Stone, placed int, rng *rand.Rand) Stone {
+	buf := make([][2]int, 0, bb.size*bb.size)
+	for {
+		moves := bb.Near(buf[:0])
+		if len(moves) == 0 {
+			moves = bb.Empties(buf[:0])
+		}
+		if len(moves) == 0 {
+			return 0
+		}
+		m := moves[rng.Intn(len(moves))]
+		blocking := false
+		for _, c := range moves {
+			if bb.WinIf(c[0], c[1], p) {
+				return p
+			}
+			if !blocking && bb.WinIf(c[0], c[1], 3-p) {
+				m = c
+				blocking = true
+			}
+		}
+		bb.Place(m[0], m[1], p)
+		placed++
+		p = rule.Next(p, placed)
 	}
-	n.children = append(n.children, child)
-	return child
 }
 
-func simulate(n *node) Stone {
-	b := n.board
-	p := n.player
+// capturePlayout finishes a game with captures from b with p to move, by
+// random moves next to the stones. The bitboard cannot take stones off, so
+// the playout runs on the board itself.
+func capturePlayout(b *Board, p Stone, rng *rand.Rand) Stone {
+	goal := b.Rule.CaptureGoal()
 	for {
-		if win, ok := checkWin(b); ok {
-			return win
+		moves := localMoves(*b, 1)
+		if len(moves) == 0 {
+			moves = emptyPoints(*b)
 		}
-		moves := legalMoves(b)
 		if len(moves) == 0 {
 			return 0
 		}
-		m := moves[rand.Intn(len(moves))]
-		b[m[0]][m[1]] = p
+		m := moves[rng.Intn(len(moves))]
+		b.Play(m[0], m[1], p)
+		if b.captured[p] >= goal || winsAt(b, m[0], m[1], p) {
+			return p
+		}
 		p = 3 - p
 	}
 }
 
-func backpropagate(n *node, winner Stone) {
-	for n != nil {
+// winsAt reports whether the stone p just played on row, col completes a
+// winning line.
+func winsAt(b *Board, row, col int, p Stone) bool {
+	b.Set(row, col, Empty)
+	defer b.Set(row, col, p)
+	return makesWin(b, row, col, p)
+}
+
+// localMoves lists the empty points within radius steps of a stone.
+func localMoves(b Board, radius int) [][2]int {
+	var moves [][2]int
+	for r := 0; r < b.Size; r++ {
+		for c := 0; c < b.Size; c++ {
+			if b.At(r, c) == Empty && hasNeighbor(&b, r, c, radius) {
+				moves = append(moves, [2]int{r, c})
+			}
+		}
+	}
+	return moves
+}
+
+func hasNeighbor(b *Board, row, col, radius int) bool {
+	for dr := -radius; dr <= radius; dr++ {
+		for dc := -radius; dc <= radius; dc++ {
+			r, c := row+dr, col+dc
+			if b.OnBoard(r, c) && b.At(r, c) != Empty {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addVirtualLoss counts the visit of a playout that is still running, as a
+// loss until backpropagate adds its result.
+func addVirtualLoss(path []*node) {
+	for _, n := range path {
 		n.visits++
+	}
+}
+
+// backpropagate adds the result of a playout whose visit was already
+// counted by addVirtualLoss.
+func backpropagate(path []*node, winner Stone) {
+	for _, n := range path {
 		if winner == 0 {
 			n.wins += 0.5
-		} else if winner == n.player {
-			n.wins += 0
-		} else {
+		} else if winner == n.mover {
 			n.wins += 1
 		}
-		n = n.parent
 	}
 }
 
-func bestUCTChild(n *node) *node {
+func bestUCTChild(n *node, exploration float64) *edge {
 	logN := math.Log(n.visits)
 	best := -1.0
-	var bestN *node
-	for _, c := range n.children {
-		uct := c.wins/c.visits + 1.41*math.Sqrt(logN/c.visits)
+	var bestE *edge
+	for i, e := range n.children {
+		c := e.node
+		uct := c.wins/c.visits + exploration*math.Sqrt(logN/c.visits)
 		if uct > best {
 			best = uct
-			bestN = c
+			bestE = &n.children[i]
 		}
 	}
-	return bestN
+	return bestE
+}
+
+func legalMoves(b Board, rng *rand.Rand) [][2]int {
+	moves := emptyPoints(b)
+	rng.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+	return moves
 }
 
-func legalMoves(b Board) [][2]int {
+func emptyPoints(b Board) [][2]int {
 	var moves [][2]int
-	for r := 0; r < BoardSize; r++ {
-		for c := 0; c < BoardSize; c++ {
-			if b[r][c] == Empty {
+	for r := 0; r < b.Size; r++ {
+		for c := 0; c < b.Size; c++ {
+			if b.At(r, c) == Empty {
 				moves = append(moves, [2]int{r, c})
 			}
 		}
 	}
-	rand.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
 	return moves
 }
 
+// checkWin returns the winner and true if the game on b is over, with
+// Empty for a full board.
 func checkWin(b Board) (Stone, bool) {
-	dirs := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
-	for r := 0; r < BoardSize; r++ {
-		for c := 0; c < BoardSize; c++ {
-			if b[r][c] == Empty {
-				continue
-			}
-			p := b[r][c]
-			for _, d := range dirs {
-				cnt := 1
-				for step := 1; step < 5; step++ {
-					nr, nc := r+d[0]*step, c+d[1]*step
-					if nr < 0 || nr >= BoardSize || nc < 0 || nc >= BoardSize || b[nr][nc] != p {
-						break
-					}
-					cnt++
-				}
-				if cnt >= 5 {
-					return p, true
-				}
-			}
-		}
-	}
-	for r := 0; r < BoardSize; r++ {
-		for c := 0; c < BoardSize; c++ {
-			if b[r][c] == Empty {
-				return 0, false
+	if goal := b.Rule.CaptureGoal(); goal > 0 {
+		for _, s := range []Stone{Black, White} {
+			if b.captured[s] >= goal {
+				return s, true
 			}
 		}
 	}
-	return 0, true
+	bb := NewBitboard(&b)
+	return bb.Result()
 }
 
-func randomMove(b Board) (int, int) {
-	moves := legalMoves(b)
+func randomMove(b Board, p Stone, rng *rand.Rand) (int, int) {
+	moves := legalOnly(&b, emptyPoints(b), p)
 	if len(moves) == 0 {
 		return -1, -1
 	}
-	m := moves[rand.Intn(len(moves))]
+	m := moves[rng.Intn(len(moves))]
 	return m[0], m[1]
 }
@@ -0,0 +1,64 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"wuziqi/src/rules"
+)
+
+// openingBoard is the standard board after two stones each at the centre.
+func openingBoard() Board {
+	b := NewBoard(rules.Standard)
+	mid := b.Size / 2
+	b.Set(mid-1, mid-1, Black)
+	b.Set(mid, mid, Black)
+	b.Set(mid-1, mid, White)
+	b.Set(mid, mid-1, White)
+	return b
+}
+
+func TestParallelSearch(t *testing.T) {
+	s := NewSearcher(SearchProfile{
+		TimeLimit: time.Minute, Playouts: 500, Exploration: 1,
+		Rollout: RolloutHeuristic, PatternOrder: true, Workers: 4,
+	})
+	board := openingBoard()
+	row, col := s.Search(context.Background(), board, Black)
+	if row < 0 || board.At(row, col) != Empty {
+		t.Fatalf("searched move %d,%d", row, col)
+	}
+	st := s.Stats()
+	if st.Playouts != 500 || st.RootVisits != 500 {
+		t.Errorf("%d playouts, %d root visits, want 500", st.Playouts, st.RootVisits)
+	}
+	// Every node made during the search was given its moves.
+	for _, e := range s.root.children {
+		if e.node.visits > 0 && len(e.node.untried)+len(e.node.children) == 0 {
+			t.Errorf("node after %v has no moves", e.move)
+		}
+	}
+}
+
+// BenchmarkSearch runs a fixed number of playouts from the opening with
+// one worker and with several, so the speedup of the parallel search shows
+// in ns/op and playouts/s on a machine with the cores for it.
+func BenchmarkSearch(b *testing.B) {
+	board := openingBoard()
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			playouts := 0
+			for range b.N {
+				s := NewSearcher(SearchProfile{
+					TimeLimit: time.Minute, Playouts: 2000, Exploration: 1,
+					Rollout: RolloutHeuristic, PatternOrder: true, Workers: workers,
+				})
+				s.Search(context.Background(), board, Black)
+				playouts += s.Stats().Playouts
+			}
+			b.ReportMetric(float64(playouts)/b.Elapsed().Seconds(), "playouts/s")
+		})
+	}
+}
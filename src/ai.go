@@ -1,55 +1,420 @@
 package src
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
-	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"wuziqi/src/alphazero"
+	"wuziqi/src/rules"
 )
 
-func GetMCTMove(board [BoardSize][BoardSize]Stone, player Stone, difficulty DifficultyLevel) (int, int) {
-	bCopy := Board(board)
-	return BestMove(bCopy, player, difficulty)
+func init() {
+	RegisterEngine("random", func() Engine { return newRandomEngine() })
+	RegisterEngine("mcts-easy", func() Engine { return NewMCTSEngine(Profile(Easy)) })
+	RegisterEngine("mcts-medium", func() Engine { return NewMCTSEngine(Profile(Medium)) })
+	RegisterEngine("mcts-hard", func() Engine { return NewMCTSEngine(Profile(Hard)) })
+	RegisterEngine("mcts-expert", func() Engine { return NewMCTSEngine(Profile(Expert)) })
+	RegisterEngine("alphabeta-easy", func() Engine { return NewAlphaBetaEngine(AlphaBetaPreset(Easy)) })
+	RegisterEngine("alphabeta-medium", func() Engine { return NewAlphaBetaEngine(AlphaBetaPreset(Medium)) })
+	RegisterEngine("alphabeta-hard", func() Engine { return NewAlphaBetaEngine(AlphaBetaPreset(Hard)) })
+	RegisterEngine("alphabeta-expert", func() Engine { return NewAlphaBetaEngine(AlphaBetaPreset(Expert)) })
+	RegisterEngine("alphazero", func() Engine { return newAlphaZeroEngine() })
+}
+
+// randomEngine plays a uniformly random empty point.
+type randomEngine struct {
+	enginePosition
+	rand *rand.Rand
+}
+
+func newRandomEngine() *randomEngine {
+	return &randomEngine{
+		enginePosition: newEnginePosition(),
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
 }
 
-func GetAIMove(board [BoardSize][BoardSize]Stone) (row, col int) {
-	flat := make([]int, BoardSize*BoardSize)
-	for r := 0; r < BoardSize; r++ {
-		for c := 0; c < BoardSize; c++ {
-			flat[r*BoardSize+c] = int(board[r][c])
+func (e *randomEngine) Name() string { return "random" }
+
+func (e *randomEngine) Options() map[string]string { return map[string]string{} }
+
+func (e *randomEngine) SetOption(name, _ string) error {
+	return fmt.Errorf("%w %q", errUnknownOption, name)
+}
+
+func (e *randomEngine) Close() {}
+
+func (e *randomEngine) GenMove(ctx context.Context) (int, int, error) {
+	if err := ctx.Err(); errors.Is(err, context.Canceled) {
+		return -1, -1, err
+	}
+	empty := legalOnly(&e.board, emptyPoints(e.board), e.pos.ToMove())
+	if len(empty) == 0 {
+		return -1, -1, errors.New("board is full")
+	}
+	move := empty[e.rand.Intn(len(empty))]
+	return move[0], move[1], nil
+}
+
+// mctsEngine is the Monte Carlo tree search in MCT.go. Its tree follows
+// the game from move to move.
+type mctsEngine struct {
+	enginePosition
+	searcher *Searcher
+}
+
+func NewMCTSEngine(profile SearchProfile) Engine {
+	return &mctsEngine{
+		enginePosition: newEnginePosition(),
+		searcher:       NewSearcher(profile),
+	}
+}
+
+func (e *mctsEngine) NewGame(v rules.Variant) error {
+	e.searcher.Reset()
+	return e.enginePosition.NewGame(v)
+}
+
+func (e *mctsEngine) Play(row, col int) error {
+	if err := e.enginePosition.Play(row, col); err != nil {
+		return err
+	}
+	e.searcher.Advance(row, col)
+	return nil
+}
+
+func (e *mctsEngine) Undo() error {
+	e.searcher.Reset()
+	return e.enginePosition.Undo()
+}
+
+func (e *mctsEngine) Stats() SearchStats {
+	return e.searcher.Stats()
+}
+
+func (e *mctsEngine) Name() string { return "mcts" }
+
+func (e *mctsEngine) Options() map[string]string {
+	return map[string]string{
+		"time":          e.searcher.Profile.TimeLimit.String(),
+		"playouts":      strconv.Itoa(e.searcher.Profile.Playouts),
+		"exploration":   strconv.FormatFloat(e.searcher.Profile.Exploration, 'g', -1, 64),
+		"rollout":       e.searcher.Profile.Rollout.String(),
+		"workers":       strconv.Itoa(e.searcher.Profile.Workers),
+		"pattern-order": strconv.FormatBool(e.searcher.Profile.PatternOrder),
+		"threat-check":  strconv.FormatBool(e.searcher.Profile.ThreatCheck),
+		"max-nodes":     strconv.Itoa(e.searcher.Profile.MaxNodes),
+	}
+}
+
+func (e *mctsEngine) SetOption(name, value string) error {
+	switch name {
+	case "time":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		e.searcher.Profile.TimeLimit = d
+		return nil
+	case "playouts":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid playouts %q", value)
+		}
+		e.searcher.Profile.Playouts = n
+		return nil
+	case "exploration":
+		c, err := strconv.ParseFloat(value, 64)
+		if err != nil || c < 0 {
+			return fmt.Errorf("invalid exploration %q", value)
+		}
+		e.searcher.Profile.Exploration = c
+		return nil
+	case "rollout":
+		p, err := ParseRolloutPolicy(value)
+		if err != nil {
+			return err
+		}
+		e.searcher.Profile.Rollout = p
+		return nil
+	case "workers":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid workers %q", value)
+		}
+		e.searcher.Profile.Workers = n
+		return nil
+	case "pattern-order":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern-order %q", value)
+		}
+		e.searcher.Profile.PatternOrder = b
+		return nil
+	case "threat-check":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid threat-check %q", value)
+		}
+		e.searcher.Profile.ThreatCheck = b
+		return nil
+	case "max-nodes":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid max-nodes %q", value)
+		}
+		e.searcher.Profile.MaxNodes = n
+		return nil
+	}
+	return fmt.Errorf("%w %q", errUnknownOption, name)
+}
+
+func (e *mctsEngine) Close() {}
+
+func (e *mctsEngine) GenMove(ctx context.Context) (int, int, error) {
+	if e.searcher.Profile.ThreatCheck {
+		if line, ok := e.forcedWin(ctx); ok {
+			return line[0][0], line[0][1], nil
 		}
 	}
+	row, col := e.searcher.Search(ctx, e.board, e.pos.ToMove())
+	if err := ctx.Err(); errors.Is(err, context.Canceled) {
+		return -1, -1, err
+	}
+	if row < 0 {
+		return -1, -1, errors.New("MCTS found no move")
+	}
+	return row, col, nil
+}
 
-	payload, _ := json.Marshal(map[string]interface{}{"board": flat})
+// alphaBetaEngine is the negamax search in alphabeta.go.
+type alphaBetaEngine struct {
+	enginePosition
+	search *AlphaBeta
+	last   AlphaBetaResult
+}
 
-	cmd := exec.Command("python", "src/go_call_np.py")
-	cmd.Stdin = bytes.NewReader(payload)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Println("Python error:", string(out), err)
-		return -1, -1
+func NewAlphaBetaEngine(profile AlphaBetaProfile) Engine {
+	return &alphaBetaEngine{
+		enginePosition: newEnginePosition(),
+		search:         NewAlphaBeta(profile),
 	}
+}
+
+func (e *alphaBetaEngine) Name() string { return "alphabeta" }
 
-	var resp struct{ Row, Col int }
-	json.Unmarshal(out, &resp)
-	// fmt.Println("AI move:", resp.Row, resp.Col)
-	return resp.Row, resp.Col
+// Supports leaves out the capture rules, since the search takes moves back
+// by emptying the point and would lose the stones a move captured, and
+// Connect6, since negamax assumes the sides alternate.
+func (e *alphaBetaEngine) Supports(v rules.Variant) bool {
+	return v.Rule.CaptureGoal() == 0 && v.Rule.StonesPerTurn() == 1
 }
-func GetRandomAIMove(board [BoardSize][BoardSize]Stone) (int, int) {
-	empty := make([][2]int, 0)
-	for r := 0; r < BoardSize; r++ {
-		for c := 0; c < BoardSize; c++ {
-			if board[r][c] == Empty {
-				empty = append(empty, [2]int{r, c})
+
+func (e *alphaBetaEngine) NewGame(v rules.Variant) error {
+	if !e.Supports(v) {
+		return fmt.Errorf("alphabeta does not play %v", v.Rule)
+	}
+	return e.enginePosition.NewGame(v)
+}
+
+func (e *alphaBetaEngine) Options() map[string]string {
+	return map[string]string{
+		"time":         e.search.Profile.TimeLimit.String(),
+		"depth":        strconv.Itoa(e.search.Profile.MaxDepth),
+		"threat-check": strconv.FormatBool(e.search.Profile.ThreatCheck),
+	}
+}
+
+func (e *alphaBetaEngine) SetOption(name, value string) error {
+	switch name {
+	case "time":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		e.search.Profile.TimeLimit = d
+		return nil
+	case "depth":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid depth %q", value)
+		}
+		e.search.Profile.MaxDepth = n
+		return nil
+	case "threat-check":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid threat-check %q", value)
+		}
+		e.search.Profile.ThreatCheck = b
+		return nil
+	}
+	return fmt.Errorf("%w %q", errUnknownOption, name)
+}
+
+func (e *alphaBetaEngine) Close() {}
+
+func (e *alphaBetaEngine) Analysis() Analysis {
+	return Analysis{Score: e.last.Score, Depth: e.last.Depth, PV: e.last.PV}
+}
+
+func (e *alphaBetaEngine) GenMove(ctx context.Context) (int, int, error) {
+	if e.search.Profile.ThreatCheck {
+		if line, ok := e.forcedWin(ctx); ok {
+			e.last = AlphaBetaResult{Move: line[0], Score: winScore - len(line), PV: line}
+			return line[0][0], line[0][1], nil
+		}
+	}
+	res := e.search.Search(ctx, e.board, e.pos.ToMove())
+	if err := ctx.Err(); errors.Is(err, context.Canceled) {
+		return -1, -1, err
+	}
+	if res.Move[0] < 0 {
+		return -1, -1, errors.New("alpha-beta found no move")
+	}
+	e.last = res
+	return res.Move[0], res.Move[1], nil
+}
+
+var (
+	azOnce sync.Once
+	azNet  *alphazero.PolicyValueNet
+	azErr  error
+)
+
+// alphaZeroVariant is the only game the AlphaZero model was trained for.
+var alphaZeroVariant = rules.Variant{Size: 8, WinLength: 5}
+
+// alphaZeroEngine plays the 8x8 AlphaZero model through the Python worker,
+// falling back to the native Go search when Python cannot be started.
+type alphaZeroEngine struct {
+	enginePosition
+	backend    string
+	playouts   int
+	worker     *AIWorker
+	workerDown bool
+	rand       *rand.Rand
+}
+
+func newAlphaZeroEngine() *alphaZeroEngine {
+	return &alphaZeroEngine{
+		enginePosition: newEnginePosition(),
+		backend:        "auto",
+		playouts:       400,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (e *alphaZeroEngine) Name() string { return "alphazero" }
+
+func (e *alphaZeroEngine) Supports(v rules.Variant) bool {
+	v.Opening = rules.NoOpening
+	return v == alphaZeroVariant
+}
+
+func (e *alphaZeroEngine) NewGame(v rules.Variant) error {
+	if !e.Supports(v) {
+		return fmt.Errorf("alphazero plays only %v, not %v", alphaZeroVariant, v)
+	}
+	return e.enginePosition.NewGame(v)
+}
+
+func (e *alphaZeroEngine) Options() map[string]string {
+	return map[string]string{
+		"backend":  e.backend,
+		"playouts": strconv.Itoa(e.playouts),
+	}
+}
+
+// SetOption accepts backend (auto, python or native) and playouts, which
+// only applies to the native search.
+func (e *alphaZeroEngine) SetOption(name, value string) error {
+	switch name {
+	case "backend":
+		if value != "auto" && value != "python" && value != "native" {
+			return fmt.Errorf("unknown backend %q", value)
+		}
+		e.backend = value
+		return nil
+	case "playouts":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid playouts %q", value)
+		}
+		e.playouts = n
+		return nil
+	}
+	return fmt.Errorf("%w %q", errUnknownOption, name)
+}
+
+func (e *alphaZeroEngine) Close() {
+	if e.worker != nil {
+		e.worker.Close()
+		e.worker = nil
+	}
+}
+
+func (e *alphaZeroEngine) GenMove(ctx context.Context) (int, int, error) {
+	// The network often misses forcing lines, so take a proven win first.
+	if line, ok := e.forcedWin(ctx); ok {
+		return line[0][0], line[0][1], nil
+	}
+	if e.backend != "native" && e.worker == nil && !e.workerDown {
+		w := NewAIWorker("python", "src/ai_worker.py")
+		if err := w.Start(); err != nil {
+			if e.backend == "python" {
+				return -1, -1, err
 			}
+			log.Println("python engine unavailable, using built-in AlphaZero:", err)
+			e.workerDown = true
+		} else {
+			e.worker = w
 		}
 	}
-	if len(empty) == 0 {
-		return -1, -1
+	if e.worker == nil {
+		return e.nativeMove(ctx)
+	}
+	if err := e.worker.Sync(e.pos.History()); err != nil {
+		return -1, -1, err
+	}
+	return e.worker.GenMove(ctx)
+}
+
+// nativeMove runs the AlphaZero search in Go with the embedded model, using
+// the same settings as ai_worker.py.
+func (e *alphaZeroEngine) nativeMove(ctx context.Context) (int, int, error) {
+	azOnce.Do(func() { azNet, azErr = alphazero.DefaultNet() })
+	if azErr != nil {
+		return -1, -1, fmt.Errorf("load AlphaZero model: %w", azErr)
+	}
+
+	size := alphaZeroVariant.Size
+	s := alphazero.NewState(size, size, alphaZeroVariant.WinLength)
+	for r := 0; r < size; r++ {
+		for c := 0; c < size; c++ {
+			if st := e.board.At(r, c); st != Empty {
+				s.Set(r*size+c, int(st))
+			}
+		}
+	}
+	s.SetCurrentPlayer(int(e.pos.ToMove()))
+	if last, ok := e.pos.LastMove(); ok {
+		s.SetLastMove(last[0]*size + last[1])
+	}
+
+	p := alphazero.NewPlayer(azNet.PolicyValue, 5, e.playouts, e.rand)
+	move := p.Action(ctx, s)
+	if err := ctx.Err(); errors.Is(err, context.Canceled) {
+		return -1, -1, err
+	}
+	if move < 0 {
+		return -1, -1, errors.New("AlphaZero found no move")
 	}
-	move := empty[rand.Intn(len(empty))]
-	// fmt.Println("AI move:", move[0], move[1])
-	return move[0], move[1]
-	//竖 横
+	return move / size, move % size, nil
 }
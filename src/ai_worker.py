@@ -0,0 +1,123 @@
+
+import sys
+import json
+import pickle
+import numpy as np
+
+sys.path.insert(0, '.')
+
+from alphazero.game import Board
+from alphazero.mcts_alphaZero import MCTS
+from alphazero.policy_value_net_numpy import PolicyValueNetNumpy
+
+# Long-lived AlphaZero engine driven by the game over stdin/stdout.
+# One JSON request per line, one JSON response per line:
+#   {"id": 1, "cmd": "new_game", "size": 8, "n_in_row": 5}
+#   {"id": 2, "cmd": "play", "row": 3, "col": 4}
+#   {"id": 3, "cmd": "genmove"}          -> {"id": 3, "ok": true, "row": r, "col": c}
+#   {"id": 4, "cmd": "undo"}
+#   {"id": 5, "cmd": "ping"}
+#   {"id": 6, "cmd": "quit"}
+# Failures are answered with {"id": n, "ok": false, "error": "..."}.
+
+BOARD_SIZE = 8
+N_IN_ROW   = 5
+MODEL_FILE = './src/alphazero/best_policy_8_8_5.model'
+
+try:
+    with open(MODEL_FILE, 'rb') as f:
+        params = pickle.load(f)
+except:
+    with open(MODEL_FILE, 'rb') as f:
+        params = pickle.load(f, encoding='bytes')
+
+net = PolicyValueNetNumpy(BOARD_SIZE, BOARD_SIZE, params)
+
+
+class Engine(object):
+    def __init__(self):
+        self.mcts = MCTS(net.policy_value_fn, c_puct=5, n_playout=400)
+        self.new_game(BOARD_SIZE, N_IN_ROW)
+
+    def new_game(self, size, n_in_row):
+        if size != BOARD_SIZE or n_in_row != N_IN_ROW:
+            raise ValueError('model only supports %dx%d, %d in a row'
+                             % (BOARD_SIZE, BOARD_SIZE, N_IN_ROW))
+        self.board = Board(width=size, height=size, n_in_row=n_in_row)
+        self.board.init_board(start_player=0)
+        self.history = []
+        self.mcts.update_with_move(-1)
+
+    def play(self, row, col):
+        move = self.board.location_to_move([row, col])
+        if move not in self.board.availables:
+            raise ValueError('illegal move %d,%d' % (row, col))
+        if self.board.game_end()[0]:
+            raise ValueError('game is over')
+        self.board.do_move(move)
+        self.history.append(move)
+        # keep the subtree below the move that was just played
+        self.mcts.update_with_move(move)
+
+    def genmove(self):
+        if self.board.game_end()[0]:
+            raise ValueError('game is over')
+        acts, probs = self.mcts.get_move_probs(self.board, temp=1e-3)
+        move = acts[int(np.argmax(probs))]
+        row, col = self.board.move_to_location(move)
+        self.play(row, col)
+        return int(row), int(col)
+
+    def undo(self):
+        if not self.history:
+            raise ValueError('nothing to undo')
+        moves = self.history[:-1]
+        self.board.init_board(start_player=0)
+        self.history = []
+        for m in moves:
+            self.board.do_move(m)
+            self.history.append(m)
+        self.mcts.update_with_move(-1)
+
+
+def handle(engine, req):
+    cmd = req.get("cmd")
+    if cmd == "ping":
+        return {}
+    if cmd == "new_game":
+        engine.new_game(int(req.get("size", BOARD_SIZE)),
+                        int(req.get("n_in_row", N_IN_ROW)))
+        return {}
+    if cmd == "play":
+        engine.play(int(req["row"]), int(req["col"]))
+        return {}
+    if cmd == "genmove":
+        row, col = engine.genmove()
+        return {"row": row, "col": col}
+    if cmd == "undo":
+        engine.undo()
+        return {}
+    raise ValueError('unknown command %r' % cmd)
+
+
+def main():
+    engine = Engine()
+    for line in sys.stdin:
+        line = line.strip()
+        if not line:
+            continue
+        req = {}
+        try:
+            req = json.loads(line)
+            if req.get("cmd") == "quit":
+                break
+            resp = handle(engine, req)
+            resp["ok"] = True
+        except Exception as e:
+            resp = {"ok": False, "error": str(e)}
+        resp["id"] = req.get("id", 0)
+        print(json.dumps(resp, separators=(',', ':')), flush=True)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,296 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	workerStartTimeout = 30 * time.Second
+	workerMoveTimeout  = 60 * time.Second
+	workerCallTimeout  = 5 * time.Second
+)
+
+var errWorkerDied = errors.New("ai worker exited")
+
+type workerRequest struct {
+	ID     int    `json:"id"`
+	Cmd    string `json:"cmd"`
+	Row    int    `json:"row"`
+	Col    int    `json:"col"`
+	Size   int    `json:"size,omitempty"`
+	NInRow int    `json:"n_in_row,omitempty"`
+}
+
+type workerResponse struct {
+	ID    int    `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	Row   int    `json:"row"`
+	Col   int    `json:"col"`
+}
+
+// AIWorker keeps the Python AlphaZero engine (src/ai_worker.py) running
+// between moves so the model is loaded once and the search tree is reused.
+// It speaks one JSON object per line over the child's stdin/stdout, and
+// restarts the child and replays the game if it crashes.
+type AIWorker struct {
+	name string
+	args []string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	resps   chan workerResponse
+	quit    chan struct{}
+	nextID  int
+	history [][2]int
+}
+
+func NewAIWorker(name string, args ...string) *AIWorker {
+	return &AIWorker{name: name, args: args}
+}
+
+// Start launches the engine and waits until it answers a ping.
+func (w *AIWorker) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.start()
+}
+
+func (w *AIWorker) start() error {
+	w.stop()
+
+	cmd := exec.Command(w.name, w.args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start ai worker: %w", err)
+	}
+
+	resps := make(chan workerResponse)
+	quit := make(chan struct{})
+	go func() {
+		defer close(resps)
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			var resp workerResponse
+			if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+				log.Printf("ai worker: bad response %q: %v", sc.Text(), err)
+				continue
+			}
+			select {
+			case resps <- resp:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	w.cmd, w.stdin, w.resps, w.quit = cmd, stdin, resps, quit
+	w.history = nil
+	if _, err := w.roundTrip(context.Background(), workerRequest{Cmd: "ping"}, workerStartTimeout); err != nil {
+		w.stop()
+		return fmt.Errorf("ai worker health check: %w", err)
+	}
+	return nil
+}
+
+func (w *AIWorker) stop() {
+	if w.cmd == nil {
+		return
+	}
+	close(w.quit)
+	_ = json.NewEncoder(w.stdin).Encode(workerRequest{Cmd: "quit"})
+	_ = w.stdin.Close()
+	done := make(chan struct{})
+	go func(cmd *exec.Cmd) {
+		_ = cmd.Wait()
+		close(done)
+	}(w.cmd)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		_ = w.cmd.Process.Kill()
+	}
+	w.cmd, w.stdin, w.resps, w.quit = nil, nil, nil, nil
+}
+
+// Close shuts the engine down.
+func (w *AIWorker) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stop()
+}
+
+func (w *AIWorker) roundTrip(ctx context.Context, req workerRequest, timeout time.Duration) (workerResponse, error) {
+	if w.cmd == nil {
+		return workerResponse{}, errWorkerDied
+	}
+	w.nextID++
+	req.ID = w.nextID
+	if err := json.NewEncoder(w.stdin).Encode(req); err != nil {
+		return workerResponse{}, errWorkerDied
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	done := ctx.Done()
+	for {
+		select {
+		case resp, ok := <-w.resps:
+			if !ok {
+				return workerResponse{}, errWorkerDied
+			}
+			if resp.ID != req.ID {
+				continue
+			}
+			if !resp.OK {
+				return resp, fmt.Errorf("ai worker %s: %s", req.Cmd, resp.Error)
+			}
+			return resp, nil
+		case <-timer.C:
+			// A late answer would be read as the reply to the next request.
+			w.stop()
+			return workerResponse{}, fmt.Errorf("ai worker %s: timed out after %v", req.Cmd, timeout)
+		case <-done:
+			// The engine has a fixed playout count, so a deadline is not a
+			// reason to give up on its answer.
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				done = nil
+				continue
+			}
+			// It cannot be interrupted mid-search either; the next call
+			// restarts it and replays the game.
+			w.stop()
+			return workerResponse{}, ctx.Err()
+		}
+	}
+}
+
+// call sends req, restarting the engine and replaying the game once if it
+// has died.
+func (w *AIWorker) call(ctx context.Context, req workerRequest, timeout time.Duration) (workerResponse, error) {
+	resp, err := w.roundTrip(ctx, req, timeout)
+	if !errors.Is(err, errWorkerDied) {
+		return resp, err
+	}
+	log.Println("ai worker died, restarting")
+	history := w.history
+	if err := w.start(); err != nil {
+		return resp, err
+	}
+	for _, m := range history {
+		if _, err := w.roundTrip(ctx, workerRequest{Cmd: "play", Row: m[0], Col: m[1]}, workerCallTimeout); err != nil {
+			return resp, err
+		}
+	}
+	w.history = history
+	return w.roundTrip(ctx, req, timeout)
+}
+
+func (w *AIWorker) Ping() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.roundTrip(context.Background(), workerRequest{Cmd: "ping"}, workerCallTimeout)
+	return err
+}
+
+func (w *AIWorker) NewGame() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.newGame()
+}
+
+func (w *AIWorker) newGame() error {
+	if _, err := w.call(context.Background(), workerRequest{Cmd: "new_game", Size: alphaZeroVariant.Size, NInRow: alphaZeroVariant.WinLength}, workerCallTimeout); err != nil {
+		return err
+	}
+	w.history = nil
+	return nil
+}
+
+func (w *AIWorker) Play(row, col int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.play(row, col)
+}
+
+func (w *AIWorker) play(row, col int) error {
+	if _, err := w.call(context.Background(), workerRequest{Cmd: "play", Row: row, Col: col}, workerCallTimeout); err != nil {
+		return err
+	}
+	w.history = append(w.history, [2]int{row, col})
+	return nil
+}
+
+func (w *AIWorker) Undo() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.undo()
+}
+
+func (w *AIWorker) undo() error {
+	if _, err := w.call(context.Background(), workerRequest{Cmd: "undo"}, workerCallTimeout); err != nil {
+		return err
+	}
+	w.history = w.history[:len(w.history)-1]
+	return nil
+}
+
+// GenMove asks the engine for a move for the side to play and plays it.
+// Cancelling ctx stops waiting and shuts the engine down; deadlines are
+// ignored.
+func (w *AIWorker) GenMove(ctx context.Context) (int, int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	resp, err := w.call(ctx, workerRequest{Cmd: "genmove"}, workerMoveTimeout)
+	if err != nil {
+		return -1, -1, err
+	}
+	w.history = append(w.history, [2]int{resp.Row, resp.Col})
+	return resp.Row, resp.Col, nil
+}
+
+// Sync brings the engine to the position reached by history, undoing and
+// playing only the moves that differ from what it already has.
+func (w *AIWorker) Sync(history [][2]int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	common := 0
+	for common < len(history) && common < len(w.history) && history[common] == w.history[common] {
+		common++
+	}
+	if common == 0 && len(w.history) > 0 {
+		if err := w.newGame(); err != nil {
+			return err
+		}
+	}
+	for len(w.history) > common {
+		if err := w.undo(); err != nil {
+			return err
+		}
+	}
+	for _, m := range history[common:] {
+		if err := w.play(m[0], m[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package src
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// AlphaBetaProfile is the budget of one alpha-beta search.
+type AlphaBetaProfile struct {
+	TimeLimit   time.Duration
+	MaxDepth    int
+	ThreatCheck bool // play a forced win found by the threat solver without searching
+	TableSize   int  // transposition table entries; 0 for the default
+}
+
+var alphaBetaPresets = map[DifficultyLevel]AlphaBetaProfile{
+	Easy:   {TimeLimit: 500 * time.Millisecond, MaxDepth: 2},
+	Medium: {TimeLimit: 1 * time.Second, MaxDepth: 4},
+	Hard:   {TimeLimit: 3 * time.Second, MaxDepth: 12, ThreatCheck: true},
+	Expert: {TimeLimit: 8 * time.Second, MaxDepth: 20, ThreatCheck: true},
+}
+
+// AlphaBetaPreset returns the alpha-beta budget for difficulty. Custom
+// plays as Medium.
+func AlphaBetaPreset(difficulty DifficultyLevel) AlphaBetaProfile {
+	if p, ok := alphaBetaPresets[difficulty]; ok {
+		return p
+	}
+	return alphaBetaPresets[Medium]
+}
+
+// AlphaBetaMove is the alpha-beta counterpart of BestMove.
+func AlphaBetaMove(board Board, forPlayer Stone, difficulty DifficultyLevel) (int, int) {
+	res := NewAlphaBeta(AlphaBetaPreset(difficulty)).Search(context.Background(), board, forPlayer)
+	return res.Move[0], res.Move[1]
+}
+
+const (
+	winScore = 10000000
+	infScore = winScore + 1000
+
+	// maxBreadth is how many of the best-ordered moves a node searches.
+	maxBreadth = 15
+
+	defaultTableSize = 1 << 18
+)
+
+// abEntry is what the transposition table remembers of a searched node.
+type abEntry struct {
+	depth int
+	score int
+	bound abBound
+	move  [2]int
+}
+
+type abBound int8
+
+const (
+	boundExact abBound = iota
+	boundLower         // the score is at least this
+	boundUpper         // the score is at most this
+)
+
+// AlphaBetaResult is the outcome of the deepest completed iteration.
+type AlphaBetaResult struct {
+	Move  [2]int
+	Score int // for the side to move; near ±winScore for a forced result
+	Depth int
+	PV    [][2]int
+	Nodes int
+}
+
+// AlphaBeta is an iterative-deepening negamax search. Moves that complete
+// or block a winning line do not use up depth, so forcing lines of fours
+// are read to the end.
+type AlphaBeta struct {
+	Profile AlphaBetaProfile
+
+	table    *TransTable[abEntry]
+	killers  [][2][2]int
+	history  [MaxBoardSize][MaxBoardSize]int
+	prevPV   [][2]int
+	nodes    int
+	deadline time.Time
+	ctx      context.Context
+	aborted  bool
+}
+
+func NewAlphaBeta(profile AlphaBetaProfile) *AlphaBeta {
+	return &AlphaBeta{Profile: profile}
+}
+
+// Search returns the best move for forPlayer on board found within the
+// profile's budget or before ctx is done. Move is -1, -1 when the game is
+// already over.
+func (s *AlphaBeta) Search(ctx context.Context, board Board, forPlayer Stone) AlphaBetaResult {
+	res := AlphaBetaResult{Move: [2]int{-1, -1}}
+	if _, over := checkWin(board); over {
+		return res
+	}
+
+	start := time.Now()
+	s.ctx = ctx
+	s.deadline = start.Add(s.Profile.TimeLimit)
+	s.nodes = 0
+	s.aborted = false
+	s.killers = nil
+	s.prevPV = nil
+	s.history = [MaxBoardSize][MaxBoardSize]int{}
+	// The table is kept from move to move: entries stay valid for the
+	// positions they describe.
+	if size := s.tableSize(); s.table == nil || len(s.table.slots) > size || len(s.table.slots)*2 <= size {
+		s.table = NewTransTable[abEntry](size)
+	}
+
+	noMove := [2]int{-1, -1}
+	if moves := s.orderMoves(&board, forPlayer, 0, noMove, legalOnly(&board, searchMoves(&board), forPlayer)); len(moves) > 0 {
+		res.Move = moves[0]
+	}
+	hash := board.Hash()
+	for depth := 1; depth <= s.Profile.MaxDepth; depth++ {
+		var pv [][2]int
+		score := s.negamax(&board, hash, forPlayer, depth, 0, -infScore, infScore, &pv)
+		if s.aborted || len(pv) == 0 {
+			break
+		}
+		res = AlphaBetaResult{Move: pv[0], Score: score, Depth: depth, PV: pv}
+		s.prevPV = pv
+		// A proven result will not change, and the next iteration would
+		// rarely finish in the time left.
+		if score > winScore-1000 || score < -winScore+1000 || time.Since(start) > s.Profile.TimeLimit/2 {
+			break
+		}
+	}
+	res.Nodes = s.nodes
+	return res
+}
+
+func (s *AlphaBeta) tableSize() int {
+	if s.Profile.TableSize > 0 {
+		return s.Profile.TableSize
+	}
+	return defaultTableSize
+}
+
+// negamax scores b for p to move. h is the Zobrist hash of b.
+func (s *AlphaBeta) negamax(b *Board, h uint64, p Stone, depth, ply, alpha, beta int, pv *[][2]int) int {
+	s.nodes++
+	if s.nodes&1023 == 0 && (!time.Now().Before(s.deadline) || s.ctx.Err() != nil) {
+		s.aborted = true
+	}
+	if s.aborted {
+		return 0
+	}
+
+	key := positionKey(h, p)
+	ttMove := [2]int{-1, -1}
+	if e, ok := s.table.Get(key); ok {
+		ttMove = e.move
+		if ply > 0 && e.depth >= depth {
+			score := scoreFromTable(e.score, ply)
+			if e.bound == boundExact || e.bound == boundLower && score >= beta || e.bound == boundUpper && score <= alpha {
+				*pv = [][2]int{e.move}
+				return score
+			}
+		}
+	}
+
+	moves := searchMoves(b)
+	if len(moves) == 0 {
+		return 0
+	}
+	bb := NewBitboard(b)
+	var blocks [][2]int
+	for _, m := range moves {
+		if bb.WinIf(m[0], m[1], p) {
+			*pv = [][2]int{m}
+			return winScore - ply
+		}
+		if bb.WinIf(m[0], m[1], 3-p) {
+			blocks = append(blocks, m)
+		}
+	}
+	next := depth - 1
+	if len(blocks) > 0 {
+		// Only a block can save the game, and it costs no depth. Against
+		// two fives the first block is enough to see the loss.
+		if moves = legalOnly(b, blocks, p); len(moves) == 0 {
+			return -winScore + ply + 1
+		}
+		next = depth
+	} else if depth <= 0 {
+		return evaluate(b, p)
+	} else {
+		if moves = legalOnly(b, moves, p); len(moves) == 0 {
+			return 0
+		}
+		moves = s.orderMoves(b, p, ply, ttMove, moves)
+		if len(moves) > maxBreadth {
+			moves = moves[:maxBreadth]
+		}
+	}
+
+	alphaOrig := alpha
+	best, bestMove := -infScore, moves[0]
+	for _, m := range moves {
+		b.Set(m[0], m[1], p)
+		var childPV [][2]int
+		score := -s.negamax(b, toggleStone(h, m[0], m[1], p), 3-p, next, ply+1, -beta, -alpha, &childPV)
+		b.Set(m[0], m[1], Empty)
+		if s.aborted {
+			return 0
+		}
+		if score > best {
+			best, bestMove = score, m
+		}
+		if score > alpha {
+			alpha = score
+			*pv = append([][2]int{m}, childPV...)
+		}
+		if alpha >= beta {
+			s.storeKiller(ply, m)
+			s.history[m[0]][m[1]] += depth * depth
+			break
+		}
+	}
+
+	bound := boundExact
+	if best <= alphaOrig {
+		bound = boundUpper
+	} else if best >= beta {
+		bound = boundLower
+	}
+	s.table.Put(key, abEntry{depth: depth, score: scoreToTable(best, ply), bound: bound, move: bestMove})
+	return best
+}
+
+// scoreToTable makes a win score relative to the node instead of the root,
+// so that it stays right when the node is reached at another ply.
+func scoreToTable(score, ply int) int {
+	switch {
+	case score > winScore-1000:
+		return score + ply
+	case score < -winScore+1000:
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTable(score, ply int) int {
+	switch {
+	case score > winScore-1000:
+		return score - ply
+	case score < -winScore+1000:
+		return score + ply
+	}
+	return score
+}
+
+func (s *AlphaBeta) storeKiller(ply int, m [2]int) {
+	for len(s.killers) <= ply {
+		s.killers = append(s.killers, [2][2]int{{-1, -1}, {-1, -1}})
+	}
+	if s.killers[ply][0] != m {
+		s.killers[ply][1] = s.killers[ply][0]
+		s.killers[ply][0] = m
+	}
+}
+
+// orderMoves sorts moves best first: the transposition table's move and the
+// previous iteration's principal variation, then by pattern score, with
+// killers and the history table lifting quiet moves that refuted other
+// lines.
+func (s *AlphaBeta) orderMoves(b *Board, p Stone, ply int, ttMove [2]int, moves [][2]int) [][2]int {
+	bb := NewBitboard(b)
+	scores := make(map[[2]int]int, len(moves))
+	for _, m := range moves {
+		score := pointScore(&bb, m[0], m[1], p) + s.history[m[0]][m[1]]
+		if ply < len(s.killers) && (s.killers[ply][0] == m || s.killers[ply][1] == m) {
+			score += scoreOpenThree
+		}
+		if ply < len(s.prevPV) && s.prevPV[ply] == m || m == ttMove {
+			score += 2 * scoreFive
+		}
+		scores[m] = score
+	}
+	sort.SliceStable(moves, func(i, j int) bool { return scores[moves[i]] > scores[moves[j]] })
+	return moves
+}
+
+// searchMoves lists the empty points within two steps of a stone, or the
+// centre of an empty board.
+func searchMoves(b *Board) [][2]int {
+	moves := localMoves(*b, 2)
+	if mid := b.Size / 2; len(moves) == 0 && b.At(mid, mid) == Empty {
+		moves = [][2]int{{mid, mid}}
+	}
+	return moves
+}
+
+// evaluate scores every run of stones on the board by its length and open
+// ends, for p against the opponent.
+func evaluate(b *Board, p Stone) int {
+	var totals [3]int
+	for r := 0; r < b.Size; r++ {
+		for c := 0; c < b.Size; c++ {
+			s := b.At(r, c)
+			if s == Empty {
+				continue
+			}
+			for _, d := range lineDirs {
+				pr, pc := r-d[0], c-d[1]
+				if b.OnBoard(pr, pc) && b.At(pr, pc) == s {
+					continue // not the start of the run
+				}
+				run, open := 1, 0
+				var before, after Stone
+				if b.OnBoard(pr, pc) {
+					before = b.At(pr, pc)
+				}
+				nr, nc := r+d[0], c+d[1]
+				for b.OnBoard(nr, nc) && b.At(nr, nc) == s {
+					run++
+					nr, nc = nr+d[0], nc+d[1]
+				}
+				if b.OnBoard(nr, nc) {
+					after = b.At(nr, nc)
+				}
+				if b.Rule.Closes(s, before, after) {
+					continue // dead, however long
+				}
+				if b.OnBoard(pr, pc) && before == Empty {
+					open++
+				}
+				if b.OnBoard(nr, nc) && after == Empty {
+					open++
+				}
+				totals[s] += shapeScore(run, open, b.WinLength, b.Rule.OverlineWins(s))
+			}
+		}
+	}
+	return totals[p] - totals[3-p]
+}
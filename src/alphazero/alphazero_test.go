@@ -0,0 +1,86 @@
+package alphazero
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+)
+
+// parityCase is a position recorded by record_parity.py from the numpy
+// network, with the priors of its available moves and its value.
+type parityCase struct {
+	Moves  []int     `json:"moves"`
+	Priors []float64 `json:"priors"`
+	Value  float64   `json:"value"`
+}
+
+// parityTolerance allows for the numpy and Go sums running in another order.
+const parityTolerance = 1e-9
+
+func TestPolicyValueMatchesNumpy(t *testing.T) {
+	data, err := os.ReadFile("testdata/parity.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []parityCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no recorded positions")
+	}
+	net, err := DefaultNet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		s := NewState(8, 8, 5)
+		for _, m := range c.Moves {
+			s.DoMove(m)
+		}
+		priors, value := net.PolicyValue(s)
+		if len(priors) != len(c.Priors) {
+			t.Errorf("moves %v: %d priors, want %d", c.Moves, len(priors), len(c.Priors))
+			continue
+		}
+		if math.Abs(value-c.Value) > parityTolerance {
+			t.Errorf("moves %v: value %v, want %v", c.Moves, value, c.Value)
+		}
+		for i, p := range priors {
+			if math.Abs(p-c.Priors[i]) > parityTolerance {
+				t.Errorf("moves %v: prior of move %d is %v, want %v", c.Moves, s.Availables()[i], p, c.Priors[i])
+				break
+			}
+		}
+	}
+}
+
+func TestCurrentStatePlanes(t *testing.T) {
+	s := NewState(8, 8, 5)
+	s.DoMove(0)  // player 1 at h=0, w=0
+	s.DoMove(10) // player 2 at h=1, w=2
+	planes := s.CurrentState()
+	if len(planes) != 4*64 {
+		t.Fatalf("%d planes values, want %d", len(planes), 4*64)
+	}
+	// Rows are flipped, so h is drawn at row 7-h. Player 1 is to move.
+	at := func(plane, h, w int) float64 { return planes[plane*64+(7-h)*8+w] }
+	for _, c := range []struct {
+		plane, h, w int
+		want        float64
+	}{
+		{0, 0, 0, 1}, // the player to move
+		{1, 1, 2, 1}, // the opponent
+		{0, 1, 2, 0},
+		{1, 0, 0, 0},
+		{2, 1, 2, 1}, // the last move
+		{2, 0, 0, 0},
+		{3, 4, 4, 1}, // an even number of stones
+	} {
+		if got := at(c.plane, c.h, c.w); got != c.want {
+			t.Errorf("plane %d at h=%d w=%d is %v, want %v", c.plane, c.h, c.w, got, c.want)
+		}
+	}
+}
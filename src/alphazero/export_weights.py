@@ -0,0 +1,79 @@
+# -*- coding: utf-8 -*-
+"""
+Convert the pickled numpy policy-value parameters into the flat binary
+format read by the Go package in this directory, so the shipped binary
+can run the network without a Python interpreter.
+
+The numpy arrays are rebuilt from their pickled state directly, so this
+script does not need numpy to be installed.
+
+Format (all integers uint32, little endian):
+    magic "AZW1", tensor count,
+    then per tensor: ndim, dims..., float32 data in C order
+
+usage: python src/alphazero/export_weights.py [in.model] [out.bin]
+"""
+
+from __future__ import print_function
+import pickle
+import struct
+import sys
+
+MAGIC = b'AZW1'
+
+
+class _Array(object):
+    """stand-in for numpy.ndarray that only keeps the pickled state"""
+
+    def __init__(self, *args):
+        self.shape = ()
+        self.data = b''
+
+    def __setstate__(self, state):
+        # (version, shape, dtype, is_fortran, raw bytes)
+        _, self.shape, dtype, fortran, self.data = state
+        if fortran:
+            raise ValueError('fortran ordered arrays are not supported')
+        if getattr(dtype, 'kind', b'f4') not in (b'f4', 'f4'):
+            raise ValueError('only float32 parameters are supported')
+
+
+class _DType(object):
+    def __init__(self, kind, *args):
+        self.kind = kind
+
+    def __setstate__(self, state):
+        pass
+
+
+class _Unpickler(pickle.Unpickler):
+    def find_class(self, module, name):
+        if name == 'dtype':
+            return _DType
+        if name in ('ndarray', '_reconstruct'):
+            return _Array
+        raise pickle.UnpicklingError('unexpected global %s.%s' % (module, name))
+
+
+def load_params(path):
+    with open(path, 'rb') as f:
+        return _Unpickler(f, encoding='bytes').load()
+
+
+def export(params, path):
+    with open(path, 'wb') as f:
+        f.write(MAGIC)
+        f.write(struct.pack('<I', len(params)))
+        for p in params:
+            f.write(struct.pack('<I', len(p.shape)))
+            for d in p.shape:
+                f.write(struct.pack('<I', d))
+            f.write(p.data)
+
+
+if __name__ == '__main__':
+    src = sys.argv[1] if len(sys.argv) > 1 else 'src/alphazero/best_policy_8_8_5.model'
+    dst = sys.argv[2] if len(sys.argv) > 2 else 'src/alphazero/best_policy_8_8_5.bin'
+    params = load_params(src)
+    export(params, dst)
+    print('wrote', len(params), 'tensors to', dst)
@@ -0,0 +1,171 @@
+package alphazero
+
+import (
+	"context"
+	"math"
+	"math/rand"
+)
+
+// PolicyValueFunc returns the priors of s.Availables and the value of s for
+// the player to move.
+type PolicyValueFunc func(s *State) ([]float64, float64)
+
+type treeNode struct {
+	parent   *treeNode
+	actions  []int
+	children []*treeNode
+	visits   int
+	q        float64
+	p        float64
+}
+
+func (n *treeNode) expand(actions []int, priors []float64) {
+	for i, a := range actions {
+		if n.child(a) == nil {
+			n.actions = append(n.actions, a)
+			n.children = append(n.children, &treeNode{parent: n, p: priors[i]})
+		}
+	}
+}
+
+func (n *treeNode) child(action int) *treeNode {
+	for i, a := range n.actions {
+		if a == action {
+			return n.children[i]
+		}
+	}
+	return nil
+}
+
+// selectChild picks the child maximising Q + U. Ties go to the earliest
+// expanded child, as max() does over the dict in mcts_alphaZero.py.
+func (n *treeNode) selectChild(cPuct float64) (int, *treeNode) {
+	best := math.Inf(-1)
+	idx := 0
+	sqrtN := math.Sqrt(float64(n.visits))
+	for i, c := range n.children {
+		v := c.q + cPuct*c.p*sqrtN/float64(1+c.visits)
+		if v > best {
+			best = v
+			idx = i
+		}
+	}
+	return n.actions[idx], n.children[idx]
+}
+
+func (n *treeNode) update(leafValue float64) {
+	n.visits++
+	n.q += (leafValue - n.q) / float64(n.visits)
+}
+
+func (n *treeNode) updateRecursive(leafValue float64) {
+	if n.parent != nil {
+		n.parent.updateRecursive(-leafValue)
+	}
+	n.update(leafValue)
+}
+
+// MCTS is the PUCT search from mcts_alphaZero.py.
+type MCTS struct {
+	root     *treeNode
+	policy   PolicyValueFunc
+	CPuct    float64
+	NPlayout int
+}
+
+func NewMCTS(policy PolicyValueFunc, cPuct float64, nPlayout int) *MCTS {
+	return &MCTS{
+		root:     &treeNode{p: 1},
+		policy:   policy,
+		CPuct:    cPuct,
+		NPlayout: nPlayout,
+	}
+}
+
+// playout runs one simulation from the root. s is modified in place.
+func (m *MCTS) playout(s *State) {
+	node := m.root
+	for len(node.children) > 0 {
+		var action int
+		action, node = node.selectChild(m.CPuct)
+		s.DoMove(action)
+	}
+
+	priors, leafValue := m.policy(s)
+	if end, winner := s.GameEnd(); !end {
+		node.expand(s.Availables(), priors)
+	} else if winner == -1 {
+		leafValue = 0
+	} else if winner == s.CurrentPlayer() {
+		leafValue = 1
+	} else {
+		leafValue = -1
+	}
+	node.updateRecursive(-leafValue)
+}
+
+// MoveProbs runs NPlayout simulations, or fewer if ctx is done first, and
+// returns the root moves with their probabilities derived from visit counts
+// at temperature temp.
+func (m *MCTS) MoveProbs(ctx context.Context, s *State, temp float64) ([]int, []float64) {
+	for i := 0; i < m.NPlayout; i++ {
+		if i > 0 && ctx.Err() != nil {
+			break
+		}
+		m.playout(s.Copy())
+	}
+	acts := append([]int(nil), m.root.actions...)
+	logits := make([]float64, len(acts))
+	for i, c := range m.root.children {
+		logits[i] = 1 / temp * math.Log(float64(c.visits)+1e-10)
+	}
+	return acts, softmax(logits)
+}
+
+// UpdateWithMove steps the root into the subtree of lastMove, keeping its
+// statistics. Any move not in the tree, such as -1, resets the search.
+func (m *MCTS) UpdateWithMove(lastMove int) {
+	if c := m.root.child(lastMove); c != nil {
+		m.root = c
+		m.root.parent = nil
+		return
+	}
+	m.root = &treeNode{p: 1}
+}
+
+// Player is MCTSPlayer without self-play noise.
+type Player struct {
+	mcts *MCTS
+	rng  *rand.Rand
+}
+
+func NewPlayer(policy PolicyValueFunc, cPuct float64, nPlayout int, rng *rand.Rand) *Player {
+	return &Player{mcts: NewMCTS(policy, cPuct, nPlayout), rng: rng}
+}
+
+func (p *Player) Reset() { p.mcts.UpdateWithMove(-1) }
+
+// Action returns the chosen move for the player to move in s, or -1 if the
+// game is already over. The search stops early when ctx is done. The tree is
+// reset afterwards, as get_action does.
+func (p *Player) Action(ctx context.Context, s *State) int {
+	if len(s.Availables()) == 0 {
+		return -1
+	}
+	acts, probs := p.mcts.MoveProbs(ctx, s, 1e-3)
+	if len(acts) == 0 {
+		p.mcts.UpdateWithMove(-1)
+		return -1
+	}
+	move := acts[len(acts)-1]
+	r := p.rng.Float64()
+	for i, pr := range probs {
+		r -= pr
+		if r < 0 {
+			move = acts[i]
+			break
+		}
+	}
+	p.mcts.UpdateWithMove(-1)
+	return move
+}
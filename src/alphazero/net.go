@@ -0,0 +1,175 @@
+package alphazero
+
+import (
+	"fmt"
+	"math"
+)
+
+// PolicyValueNet is a port of PolicyValueNetNumpy: three 3x3 conv layers
+// followed by a policy head and a value head.
+type PolicyValueNet struct {
+	width, height int
+	params        []Tensor
+}
+
+var paramShapes = [][]int{
+	{32, 4, 3, 3}, {32},
+	{64, 32, 3, 3}, {64},
+	{128, 64, 3, 3}, {128},
+	{4, 128, 1, 1}, {4},
+	{-1, -1}, {-1},
+	{2, 128, 1, 1}, {2},
+	{-1, 64}, {64},
+	{64, 1}, {1},
+}
+
+func NewPolicyValueNet(width, height int, params []Tensor) (*PolicyValueNet, error) {
+	if len(params) != len(paramShapes) {
+		return nil, fmt.Errorf("alphazero: expected %d tensors, got %d", len(paramShapes), len(params))
+	}
+	area := width * height
+	want := make([][]int, len(paramShapes))
+	copy(want, paramShapes)
+	want[8] = []int{4 * area, area}
+	want[9] = []int{area}
+	want[12] = []int{2 * area, 64}
+	for i, shape := range want {
+		got := params[i].Shape
+		if len(got) != len(shape) {
+			return nil, fmt.Errorf("alphazero: tensor %d has shape %v, want %v", i, got, shape)
+		}
+		for j := range shape {
+			if got[j] != shape[j] {
+				return nil, fmt.Errorf("alphazero: tensor %d has shape %v, want %v", i, got, shape)
+			}
+		}
+	}
+	return &PolicyValueNet{width: width, height: height, params: params}, nil
+}
+
+func (n *PolicyValueNet) Width() int  { return n.width }
+func (n *PolicyValueNet) Height() int { return n.height }
+
+// Forward evaluates the input planes and returns the move probabilities over
+// every point of the board and the value for the player to move.
+func (n *PolicyValueNet) Forward(planes []float64) ([]float64, float64) {
+	h, w := n.height, n.width
+	x := planes
+	for i := 0; i < 6; i += 2 {
+		x = relu(conv(x, h, w, n.params[i], n.params[i+1], 1))
+	}
+
+	xp := relu(conv(x, h, w, n.params[6], n.params[7], 0))
+	probs := softmax(fc(xp, n.params[8], n.params[9]))
+
+	xv := relu(conv(x, h, w, n.params[10], n.params[11], 0))
+	xv = relu(fc(xv, n.params[12], n.params[13]))
+	value := math.Tanh(fc(xv, n.params[14], n.params[15])[0])
+	return probs, value
+}
+
+// PolicyValue is policy_value_fn: the prior of every available move in the
+// order of s.Availables, and the value of the position for the player to move.
+func (n *PolicyValueNet) PolicyValue(s *State) ([]float64, float64) {
+	probs, value := n.Forward(s.CurrentState())
+	legal := s.Availables()
+	priors := make([]float64, len(legal))
+	for i, m := range legal {
+		priors[i] = probs[m]
+	}
+	return priors, value
+}
+
+// conv is a stride 1 convolution over an h*w image, computed as a matrix
+// product with the im2col layout of the input. Like theano, the filters are
+// rotated 180 degrees before being applied.
+func conv(x []float64, h, w int, weight, bias Tensor, pad int) []float64 {
+	filters, depth, kh, kw := weight.Shape[0], weight.Shape[1], weight.Shape[2], weight.Shape[3]
+	area := h * w
+	k := depth * kh * kw
+	cols := make([]float64, k*area)
+	for c := 0; c < depth; c++ {
+		for ki := 0; ki < kh; ki++ {
+			for kj := 0; kj < kw; kj++ {
+				row := cols[((c*kh+ki)*kw+kj)*area:]
+				for r := 0; r < h; r++ {
+					sr := r + ki - pad
+					if sr < 0 || sr >= h {
+						continue
+					}
+					for col := 0; col < w; col++ {
+						sc := col + kj - pad
+						if sc < 0 || sc >= w {
+							continue
+						}
+						row[r*w+col] = x[(c*h+sr)*w+sc]
+					}
+				}
+			}
+		}
+	}
+
+	out := make([]float64, filters*area)
+	for f := 0; f < filters; f++ {
+		o := out[f*area : (f+1)*area]
+		for i := range o {
+			o[i] = bias.Data[f]
+		}
+		for c := 0; c < depth; c++ {
+			for ki := 0; ki < kh; ki++ {
+				for kj := 0; kj < kw; kj++ {
+					wv := weight.Data[((f*depth+c)*kh+kh-1-ki)*kw+kw-1-kj]
+					src := cols[((c*kh+ki)*kw+kj)*area : ((c*kh+ki)*kw+kj+1)*area]
+					dst := o[:len(src)]
+					for i, v := range src {
+						dst[i] += wv * v
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+func fc(x []float64, weight, bias Tensor) []float64 {
+	in, outN := weight.Shape[0], weight.Shape[1]
+	out := append([]float64(nil), bias.Data...)
+	for i := 0; i < in; i++ {
+		if x[i] == 0 {
+			continue
+		}
+		row := weight.Data[i*outN : (i+1)*outN]
+		for j := range out {
+			out[j] += x[i] * row[j]
+		}
+	}
+	return out
+}
+
+func relu(x []float64) []float64 {
+	for i, v := range x {
+		if v < 0 {
+			x[i] = 0
+		}
+	}
+	return x
+}
+
+func softmax(x []float64) []float64 {
+	max := math.Inf(-1)
+	for _, v := range x {
+		if v > max {
+			max = v
+		}
+	}
+	sum := 0.0
+	out := make([]float64, len(x))
+	for i, v := range x {
+		out[i] = math.Exp(v - max)
+		sum += out[i]
+	}
+	for i := range out {
+		out[i] /= sum
+	}
+	return out
+}
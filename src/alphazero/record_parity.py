@@ -0,0 +1,68 @@
+# -*- coding: utf-8 -*-
+"""
+Record the priors and values the numpy policy-value network gives for a few
+fixed positions, for the Go port to be checked against in
+alphazero_test.go.
+
+Each position is a list of moves played from the empty board by
+Board.do_move, player 1 first. The priors are those of policy_value_fn, in
+the order of board.availables.
+
+usage: python src/alphazero/record_parity.py [in.model] [out.json]
+"""
+
+from __future__ import print_function
+import json
+import os
+import pickle
+import sys
+
+sys.path.insert(0, os.path.dirname(os.path.abspath(__file__)))
+
+from game import Board
+from policy_value_net_numpy import PolicyValueNetNumpy
+
+WIDTH = HEIGHT = 8
+N_IN_ROW = 5
+
+POSITIONS = [
+    [],
+    [0],
+    [27, 28, 35, 36, 19, 20, 43],
+    [7, 14, 21, 1, 50, 63, 56, 9],
+    [27, 36, 28, 29, 35, 43, 26, 25, 44, 18, 20, 34, 19, 37, 12, 11],
+]
+
+
+def load_params(path):
+    with open(path, 'rb') as f:
+        try:
+            return pickle.load(f)
+        except UnicodeDecodeError:
+            f.seek(0)
+            return pickle.load(f, encoding='bytes')
+
+
+def record(net, moves):
+    board = Board(width=WIDTH, height=HEIGHT, n_in_row=N_IN_ROW)
+    board.init_board()
+    for m in moves:
+        board.do_move(m)
+    act_probs, value = net.policy_value_fn(board)
+    return {
+        'moves': moves,
+        'priors': [float(p) for _, p in act_probs],
+        'value': float(value),
+    }
+
+
+if __name__ == '__main__':
+    here = os.path.dirname(os.path.abspath(__file__))
+    src = sys.argv[1] if len(sys.argv) > 1 else os.path.join(here, 'best_policy_8_8_5.model')
+    dst = sys.argv[2] if len(sys.argv) > 2 else os.path.join(here, 'testdata', 'parity.json')
+    net = PolicyValueNetNumpy(WIDTH, HEIGHT, load_params(src))
+    cases = [record(net, moves) for moves in POSITIONS]
+    with open(dst, 'w') as f:
+        json.dump(cases, f, indent=1)
+        f.write('\n')
+    print('wrote', len(cases), 'positions to', dst)
@@ -0,0 +1,154 @@
+package alphazero
+
+// State is the board as seen by the network, ported from Board in game.py.
+// Moves are indexed h*Width + w, players are 1 and 2.
+type State struct {
+	Width, Height, NInRow int
+
+	states     []int
+	current    int
+	lastMove   int
+	availables []int
+	count      int
+}
+
+func NewState(width, height, nInRow int) *State {
+	s := &State{
+		Width:    width,
+		Height:   height,
+		NInRow:   nInRow,
+		states:   make([]int, width*height),
+		current:  1,
+		lastMove: -1,
+	}
+	s.availables = make([]int, width*height)
+	for i := range s.availables {
+		s.availables[i] = i
+	}
+	return s
+}
+
+// Set puts a stone on the board without passing the turn, for loading
+// positions that were not reached through DoMove.
+func (s *State) Set(move, player int) {
+	if s.states[move] != 0 {
+		return
+	}
+	s.states[move] = player
+	s.count++
+	s.removeAvailable(move)
+}
+
+func (s *State) SetCurrentPlayer(p int) { s.current = p }
+
+func (s *State) SetLastMove(move int) { s.lastMove = move }
+
+func (s *State) CurrentPlayer() int { return s.current }
+
+func (s *State) LastMove() int { return s.lastMove }
+
+// Availables lists the empty points in ascending order. The slice is
+// owned by the state.
+func (s *State) Availables() []int { return s.availables }
+
+func (s *State) At(move int) int { return s.states[move] }
+
+func (s *State) DoMove(move int) {
+	s.states[move] = s.current
+	s.count++
+	s.removeAvailable(move)
+	s.current = 3 - s.current
+	s.lastMove = move
+}
+
+func (s *State) removeAvailable(move int) {
+	for i, m := range s.availables {
+		if m == move {
+			s.availables = append(s.availables[:i], s.availables[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *State) Copy() *State {
+	c := *s
+	c.states = append([]int(nil), s.states...)
+	c.availables = append([]int(nil), s.availables...)
+	return &c
+}
+
+// CurrentState builds the 4 input planes from the perspective of the player
+// to move, rows flipped like current_state in game.py.
+func (s *State) CurrentState() []float64 {
+	area := s.Width * s.Height
+	planes := make([]float64, 4*area)
+	at := func(plane, move int) int {
+		h, w := move/s.Width, move%s.Width
+		return plane*area + (s.Height-1-h)*s.Width + w
+	}
+	if s.count > 0 {
+		for m, p := range s.states {
+			switch {
+			case p == 0:
+			case p == s.current:
+				planes[at(0, m)] = 1
+			default:
+				planes[at(1, m)] = 1
+			}
+		}
+		if s.lastMove >= 0 {
+			planes[at(2, s.lastMove)] = 1
+		}
+	}
+	if s.count%2 == 0 {
+		for i := 3 * area; i < 4*area; i++ {
+			planes[i] = 1
+		}
+	}
+	return planes
+}
+
+func (s *State) hasWinner() (bool, int) {
+	w, h, n := s.Width, s.Height, s.NInRow
+	if s.count < n*2-1 {
+		return false, -1
+	}
+	line := func(m, step, player int) bool {
+		for i := 0; i < n; i++ {
+			if s.states[m+i*step] != player {
+				return false
+			}
+		}
+		return true
+	}
+	for m, player := range s.states {
+		if player == 0 {
+			continue
+		}
+		row, col := m/w, m%w
+		if col <= w-n && line(m, 1, player) {
+			return true, player
+		}
+		if row <= h-n && line(m, w, player) {
+			return true, player
+		}
+		if col <= w-n && row <= h-n && line(m, w+1, player) {
+			return true, player
+		}
+		if col >= n-1 && row <= h-n && line(m, w-1, player) {
+			return true, player
+		}
+	}
+	return false, -1
+}
+
+// GameEnd reports whether the game is over and the winner, -1 for a tie.
+func (s *State) GameEnd() (bool, int) {
+	if win, winner := s.hasWinner(); win {
+		return true, winner
+	}
+	if len(s.availables) == 0 {
+		return true, -1
+	}
+	return false, -1
+}
@@ -0,0 +1,76 @@
+package alphazero
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// best_policy_8_8_5.bin is produced from best_policy_8_8_5.model by
+// export_weights.py.
+//
+//go:embed best_policy_8_8_5.bin
+var defaultWeights []byte
+
+var weightsMagic = [4]byte{'A', 'Z', 'W', '1'}
+
+// Tensor is one parameter array of the network in C order.
+type Tensor struct {
+	Shape []int
+	Data  []float64
+}
+
+// LoadWeights reads the parameter list written by export_weights.py.
+func LoadWeights(r io.Reader) ([]Tensor, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != weightsMagic {
+		return nil, errors.New("alphazero: not a weights file")
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	params := make([]Tensor, count)
+	for i := range params {
+		var ndim uint32
+		if err := binary.Read(r, binary.LittleEndian, &ndim); err != nil {
+			return nil, err
+		}
+		dims := make([]uint32, ndim)
+		if err := binary.Read(r, binary.LittleEndian, dims); err != nil {
+			return nil, err
+		}
+		size := 1
+		shape := make([]int, ndim)
+		for j, d := range dims {
+			shape[j] = int(d)
+			size *= int(d)
+		}
+		raw := make([]byte, size*4)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, fmt.Errorf("alphazero: tensor %d: %w", i, err)
+		}
+		data := make([]float64, size)
+		for j := range data {
+			data[j] = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[j*4:])))
+		}
+		params[i] = Tensor{Shape: shape, Data: data}
+	}
+	return params, nil
+}
+
+// DefaultNet returns the 8x8, five-in-a-row network shipped with the game.
+func DefaultNet() (*PolicyValueNet, error) {
+	params, err := LoadWeights(bytes.NewReader(defaultWeights))
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicyValueNet(8, 8, params)
+}
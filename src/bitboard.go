@@ -0,0 +1,284 @@
+package src
+
+import (
+	"math/bits"
+
+	"wuziqi/src/rules"
+)
+
+// lineCount is the number of lines in the longest direction, the
+// diagonals of the largest board.
+const lineCount = 2*MaxBoardSize - 1
+
+// Bitboard keeps a board as bit lines: for each colour and each of the four
+// directions in lineDirs, one word per line of the board with a bit per
+// point along it. Placing a stone sets four bits, and whether a line holds
+// a win is a few shifts of one word.
+type Bitboard struct {
+	lines     [3][4][lineCount]uint32
+	count     int
+	size      int
+	winLength int
+	overline  [3]bool // whether a line longer than winLength wins, by colour
+	closes    bool    // whether a line closed at both ends by the opponent fails
+}
+
+var (
+	// lineMasks has the bits of each line that lie on a board of each size.
+	lineMasks [MaxBoardSize + 1][4][lineCount]uint32
+	// shapeTable maps a nine-point window of a line, centred on the point
+	// being played, to the run through the centre and its open ends. The
+	// index is the stones of the player in the low nine bits and the
+	// blocked points (opponent or off the board) in the high nine.
+	shapeTable [1 << 18]uint8
+)
+
+func init() {
+	for size := 1; size <= MaxBoardSize; size++ {
+		for row := 0; row < size; row++ {
+			for col := 0; col < size; col++ {
+				for dir := range lineDirs {
+					line, pos := lineIndex(dir, row, col)
+					lineMasks[size][dir][line] |= 1 << pos
+				}
+			}
+		}
+	}
+	for i := range shapeTable {
+		own, blocked := uint32(i)&0x1ff|1<<4, uint32(i)>>9
+		if own&blocked != 0 {
+			continue
+		}
+		run, open := 1, 0
+		for _, step := range [2]int{1, -1} {
+			at := 4 + step
+			for at >= 0 && at < 9 && own&(1<<at) != 0 {
+				run++
+				at += step
+			}
+			if at >= 0 && at < 9 && blocked&(1<<at) == 0 {
+				open++
+			}
+		}
+		shapeTable[i] = uint8(run<<2 | open)
+	}
+}
+
+// lineIndex returns the line through row, col in direction dir and the
+// point's position along it. Lines are numbered the same on every board
+// size.
+func lineIndex(dir, row, col int) (line, pos int) {
+	switch dir {
+	case 0:
+		return row, col
+	case 1:
+		return col, row
+	case 2:
+		return col - row + MaxBoardSize - 1, row
+	}
+	return row + col, row
+}
+
+func NewBitboard(b *Board) Bitboard {
+	bb := Bitboard{size: b.Size, winLength: b.WinLength, closes: b.Rule == rules.Caro}
+	for _, s := range []Stone{Black, White} {
+		bb.overline[s] = b.Rule.OverlineWins(s)
+	}
+	for row := 0; row < b.Size; row++ {
+		for col := 0; col < b.Size; col++ {
+			if s := b.At(row, col); s != Empty {
+				bb.Place(row, col, s)
+			}
+		}
+	}
+	return bb
+}
+
+func (bb *Bitboard) Place(row, col int, s Stone) {
+	for dir := range lineDirs {
+		line, pos := lineIndex(dir, row, col)
+		bb.lines[s][dir][line] |= 1 << pos
+	}
+	bb.count++
+}
+
+func (bb *Bitboard) Remove(row, col int) {
+	s := bb.At(row, col)
+	if s == Empty {
+		return
+	}
+	for dir := range lineDirs {
+		line, pos := lineIndex(dir, row, col)
+		bb.lines[s][dir][line] &^= 1 << pos
+	}
+	bb.count--
+}
+
+func (bb *Bitboard) At(row, col int) Stone {
+	switch {
+	case bb.lines[Black][0][row]&(1<<col) != 0:
+		return Black
+	case bb.lines[White][0][row]&(1<<col) != 0:
+		return White
+	}
+	return Empty
+}
+
+// Line returns the stones of s on the line through row, col in direction
+// dir, and the point's bit position in it.
+func (bb *Bitboard) Line(dir, row, col int, s Stone) (line uint32, pos int) {
+	l, pos := lineIndex(dir, row, col)
+	return bb.lines[s][dir][l], pos
+}
+
+// runSpan returns the first and last bit of the run of set bits in x
+// through bit pos, which must be set.
+func runSpan(x uint32, pos int) (lo, hi int) {
+	lo, hi = pos, pos+bits.TrailingZeros32(^(x>>(pos+1)))
+	if pos > 0 {
+		lo -= bits.LeadingZeros32(^(x << (32 - pos)))
+	}
+	return lo, hi
+}
+
+// WinAt reports whether the stone s at row, col is part of a winning line.
+func (bb *Bitboard) WinAt(row, col int, s Stone) bool {
+	for dir := range lineDirs {
+		if line, pos := lineIndex(dir, row, col); bb.winsLine(dir, line, bb.lines[s][dir][line], pos, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// WinIf reports whether s playing the empty point row, col would make a
+// winning line.
+func (bb *Bitboard) WinIf(row, col int, s Stone) bool {
+	for dir := range lineDirs {
+		if bb.WinIfAlong(dir, row, col, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// WinIfAlong reports whether s playing the empty point row, col would make
+// a winning line in direction dir.
+func (bb *Bitboard) WinIfAlong(dir, row, col int, s Stone) bool {
+	line, pos := lineIndex(dir, row, col)
+	return bb.winsLine(dir, line, bb.lines[s][dir][line]|1<<pos, pos, s)
+}
+
+// winsLine reports whether the run of s through bit pos of the line in
+// direction dir wins, with x the stones of s on the line.
+func (bb *Bitboard) winsLine(dir, line int, x uint32, pos int, s Stone) bool {
+	if x&(1<<pos) == 0 {
+		return false
+	}
+	lo, hi := runSpan(x, pos)
+	if !bb.wins(hi-lo+1, s) {
+		return false
+	}
+	opp := bb.lines[3-s][dir][line]
+	return !bb.closes || lo == 0 || opp&(1<<(lo-1)) == 0 || opp&(1<<(hi+1)) == 0
+}
+
+// Shape looks up the run s would have through the empty point row, col in
+// direction dir if it played there, and how many of the run's ends are
+// empty. Runs longer than the window reads are cut to nine.
+func (bb *Bitboard) Shape(dir, row, col int, s Stone) (run, open int) {
+	line, pos := lineIndex(dir, row, col)
+	// Shift the line up by four so the window below the first point reads
+	// as off the board.
+	own := uint64(bb.lines[s][dir][line]) << 4
+	blocked := (uint64(bb.lines[3-s][dir][line]) | ^uint64(lineMasks[bb.size][dir][line])) << 4
+	blocked |= 0xf
+	v := shapeTable[(own>>pos)&0x1ff|((blocked>>pos)&0x1ff)<<9]
+	return int(v >> 2), int(v & 3)
+}
+
+// Result returns the winner and true if the game is over, with Empty for a
+// full board.
+func (bb *Bitboard) Result() (Stone, bool) {
+	for _, s := range []Stone{Black, White} {
+		for dir := range lineDirs {
+			for line, x := range bb.lines[s][dir] {
+				if bb.closes && bb.hasOpenRun(x, bb.lines[3-s][dir][line], s) ||
+					!bb.closes && hasRun(x, bb.winLength, !bb.overline[s]) {
+					return s, true
+				}
+			}
+		}
+	}
+	return Empty, bb.count == bb.size*bb.size
+}
+
+func (bb *Bitboard) wins(run int, s Stone) bool {
+	return run == bb.winLength || run > bb.winLength && bb.overline[s]
+}
+
+// hasRun reports whether x has n set bits in a row, or exactly n if exact.
+func hasRun(x uint32, n int, exact bool) bool {
+	starts := x
+	for i := 1; i < n && starts != 0; i++ {
+		starts &= x >> i
+	}
+	if exact {
+		// Keep the runs with a clear bit on either side.
+		starts &^= x<<1 | x>>n
+	}
+	return starts != 0
+}
+
+// hasOpenRun reports whether x, the stones of s on a line, has a winning
+// run that opp, the opponent's stones on it, does not close at both ends.
+func (bb *Bitboard) hasOpenRun(x, opp uint32, s Stone) bool {
+	for x != 0 {
+		lo := bits.TrailingZeros32(x)
+		run := bits.TrailingZeros32(^(x >> lo))
+		if bb.wins(run, s) && (lo == 0 || opp&(1<<(lo-1)) == 0 || opp&(1<<(lo+run)) == 0) {
+			return true
+		}
+		x &^= 1<<(lo+run) - 1
+	}
+	return false
+}
+
+// Empties appends the empty points to buf.
+func (bb *Bitboard) Empties(buf [][2]int) [][2]int {
+	for row := 0; row < bb.size; row++ {
+		free := lineMasks[bb.size][0][row] &^ (bb.lines[Black][0][row] | bb.lines[White][0][row])
+		buf = appendRow(buf, row, free)
+	}
+	return buf
+}
+
+// Near appends the empty points next to a stone to buf.
+func (bb *Bitboard) Near(buf [][2]int) [][2]int {
+	var spread [MaxBoardSize]uint32
+	for row := 0; row < bb.size; row++ {
+		occ := bb.lines[Black][0][row] | bb.lines[White][0][row]
+		spread[row] = occ | occ<<1 | occ>>1
+	}
+	for row := 0; row < bb.size; row++ {
+		near := spread[row]
+		if row > 0 {
+			near |= spread[row-1]
+		}
+		if row < bb.size-1 {
+			near |= spread[row+1]
+		}
+		occ := bb.lines[Black][0][row] | bb.lines[White][0][row]
+		buf = appendRow(buf, row, near&^occ&lineMasks[bb.size][0][row])
+	}
+	return buf
+}
+
+func appendRow(buf [][2]int, row int, cols uint32) [][2]int {
+	for cols != 0 {
+		col := bits.TrailingZeros32(cols)
+		buf = append(buf, [2]int{row, col})
+		cols &= cols - 1
+	}
+	return buf
+}
@@ -0,0 +1,198 @@
+package src
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"wuziqi/src/rules"
+)
+
+var caro = rules.Variant{Size: 15, WinLength: 5, Rule: rules.Caro}
+
+// caroLine is a line drawn from start along d with X for the player, O for
+// the opponent and * for the point the player takes.
+type caroLine struct {
+	name  string
+	start [2]int
+	d     [2]int
+	line  string
+	wins  bool
+}
+
+var caroLines = []caroLine{
+	{"five at the left edge", [2]int{7, 0}, [2]int{0, 1}, "XXXX*O", true},
+	{"five at the right edge", [2]int{7, 9}, [2]int{0, 1}, "OXXXX*", true},
+	{"five at the top edge", [2]int{0, 3}, [2]int{1, 0}, "*XXXXO", true},
+	{"five at the bottom edge", [2]int{9, 3}, [2]int{1, 0}, "OXX*XX", true},
+	{"five into the corner", [2]int{0, 0}, [2]int{1, 1}, "XX*XXO", true},
+	{"five into the other corner", [2]int{0, 14}, [2]int{1, -1}, "XXXX*O", true},
+	{"diagonal five at the side edge", [2]int{5, 0}, [2]int{1, 1}, "XXXX*O", true},
+	{"diagonal five ending at the side edge", [2]int{3, 8}, [2]int{1, 1}, "OXXXX*", true},
+	{"anti-diagonal five at the side edge", [2]int{4, 14}, [2]int{1, -1}, "*XXXXO", true},
+	{"five closed at both ends", [2]int{7, 3}, [2]int{0, 1}, "OXXXX*O", false},
+	{"column five closed at both ends", [2]int{2, 7}, [2]int{1, 0}, "OX*XXXO", false},
+	{"diagonal five closed at both ends", [2]int{2, 2}, [2]int{1, 1}, "OXXX*XO", false},
+	{"anti-diagonal five closed at both ends", [2]int{2, 12}, [2]int{1, -1}, "O*XXXXO", false},
+	{"five closed at the start", [2]int{7, 3}, [2]int{0, 1}, "OXXXX*.", true},
+	{"five closed at the end", [2]int{7, 3}, [2]int{0, 1}, ".XX*XXO", true},
+	{"five closed one point away", [2]int{7, 2}, [2]int{0, 1}, "OXXXX*.O", true},
+	{"open overline", [2]int{7, 3}, [2]int{0, 1}, ".XXX*XX.", true},
+	{"overline closed at both ends", [2]int{7, 3}, [2]int{0, 1}, "OXXX*XXO", false},
+	{"overline closed at one end", [2]int{7, 3}, [2]int{0, 1}, "OXXXXX*.", true},
+	{"overline at the edge", [2]int{7, 0}, [2]int{0, 1}, "XXX*XXXO", true},
+	{"closed four", [2]int{7, 3}, [2]int{0, 1}, "OXXX*O", false},
+}
+
+// draw puts l on a Caro board for s, and returns the board and the point
+// marked *.
+func (l caroLine) draw(t *testing.T, s Stone) (Board, [2]int) {
+	t.Helper()
+	b := NewBoard(caro)
+	var star [2]int
+	for i, ch := range l.line {
+		r, c := l.start[0]+i*l.d[0], l.start[1]+i*l.d[1]
+		if !b.OnBoard(r, c) {
+			t.Fatalf("%q runs off the board at %d,%d", l.line, r, c)
+		}
+		switch ch {
+		case 'X':
+			b.Set(r, c, s)
+		case 'O':
+			b.Set(r, c, 3-s)
+		case '*':
+			star = [2]int{r, c}
+		}
+	}
+	if strings.Count(l.line, "*") != 1 {
+		t.Fatalf("%q marks %d points", l.line, strings.Count(l.line, "*"))
+	}
+	return b, star
+}
+
+func TestCaroLines(t *testing.T) {
+	for _, l := range caroLines {
+		t.Run(l.name, func(t *testing.T) {
+			for _, s := range []Stone{Black, White} {
+				b, m := l.draw(t, s)
+				bb := NewBitboard(&b)
+				if got := bb.WinIf(m[0], m[1], s); got != l.wins {
+					t.Errorf("%v: WinIf = %v", s, got)
+				}
+				if got := makesWin(&b, m[0], m[1], s); got != l.wins {
+					t.Errorf("%v: makesWin = %v", s, got)
+				}
+				bb.Place(m[0], m[1], s)
+				if got := bb.WinAt(m[0], m[1], s); got != l.wins {
+					t.Errorf("%v: WinAt = %v", s, got)
+				}
+				if winner, over := bb.Result(); over != l.wins || l.wins && winner != s {
+					t.Errorf("%v: Result = %v, %v", s, winner, over)
+				}
+			}
+		})
+	}
+}
+
+// benchBoard returns a middle-game board: forty stones scattered over the
+// centre without a winning line.
+func benchBoard() Board {
+	b := NewBoard(rules.Standard)
+	rng := rand.New(rand.NewSource(1))
+	for s, placed := Black, 0; placed < 40; {
+		r, c := 3+rng.Intn(9), 3+rng.Intn(9)
+		if b.At(r, c) != Empty || makesWin(&b, r, c, s) {
+			continue
+		}
+		b.Set(r, c, s)
+		s = 3 - s
+		placed++
+	}
+	return b
+}
+
+// BenchmarkWinIf checks every empty point of a middle-game board for a
+// five, by scanning the board array and by the bitboard.
+func BenchmarkWinIf(b *testing.B) {
+	board := benchBoard()
+	empties := emptyPoints(board)
+	b.Run("array", func(b *testing.B) {
+		for range b.N {
+			for _, m := range empties {
+				makesWin(&board, m[0], m[1], Black)
+			}
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		bb := NewBitboard(&board)
+		for range b.N {
+			for _, m := range empties {
+				bb.WinIf(m[0], m[1], Black)
+			}
+		}
+	})
+}
+
+// BenchmarkShape measures the run through every empty point in every
+// direction, as pattern scoring does.
+func BenchmarkShape(b *testing.B) {
+	board := benchBoard()
+	empties := emptyPoints(board)
+	b.Run("array", func(b *testing.B) {
+		for range b.N {
+			for _, m := range empties {
+				for _, d := range lineDirs {
+					lineShape(&board, m[0], m[1], d[0], d[1], Black)
+				}
+			}
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		bb := NewBitboard(&board)
+		for range b.N {
+			for _, m := range empties {
+				for dir := range lineDirs {
+					bb.Shape(dir, m[0], m[1], Black)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkResult checks a whole board for a win, from scratch: by each
+// stone on the array, and by building a bitboard.
+func BenchmarkResult(b *testing.B) {
+	board := benchBoard()
+	b.Run("array", func(b *testing.B) {
+		for range b.N {
+			for r := 0; r < board.Size; r++ {
+				for c := 0; c < board.Size; c++ {
+					if s := board.At(r, c); s != Empty && winsAt(&board, r, c, s) {
+						b.Fatal("the board has a five")
+					}
+				}
+			}
+		}
+	})
+	b.Run("bitboard", func(b *testing.B) {
+		for range b.N {
+			if _, over := checkWin(board); over {
+				b.Fatal("the board has a five")
+			}
+		}
+	})
+}
+
+// BenchmarkPlayout plays games out from a middle-game board with each
+// rollout policy.
+func BenchmarkPlayout(b *testing.B) {
+	board := benchBoard()
+	for _, policy := range []RolloutPolicy{RolloutRandom, RolloutLocal, RolloutHeuristic} {
+		b.Run(policy.String(), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			for range b.N {
+				simulate(board, Black, 40, policy, rng)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package src
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"time"
+
+	"wuziqi/utils"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// chatHistory is how many lines of chat are kept.
+const chatHistory = 100
+
+// quickPhrases are sent with a key each.
+var quickPhrases = []struct {
+	key  ebiten.Key
+	text string
+}{
+	{ebiten.KeyF1, "Good game"},
+	{ebiten.KeyF2, "Nice move"},
+	{ebiten.KeyF3, "Good luck, have fun"},
+	{ebiten.KeyF4, "Thanks"},
+}
+
+type chatLine struct {
+	at   time.Time
+	from string
+	text string
+	mine bool
+}
+
+// chatPanel is the chat of a LAN game: the lines so far and the one being
+// typed. It lasts as long as the connection, rematches included.
+type chatPanel struct {
+	lines  []chatLine
+	open   bool // shown beside the board
+	typing bool
+	draft  []rune
+	unread int  // lines received while the panel was closed
+	keys   bool // the keyboard types into the chat this frame
+	width  int  // the window width last set
+}
+
+func (c *chatPanel) add(l chatLine) {
+	c.lines = append(c.lines, l)
+	if len(c.lines) > chatHistory {
+		c.lines = c.lines[len(c.lines)-chatHistory:]
+	}
+	if !c.open {
+		c.unread++
+	}
+}
+
+// clear forgets the chat of a connection that has gone.
+func (c *chatPanel) clear() {
+	c.lines, c.draft, c.typing, c.unread = nil, nil, false, 0
+}
+
+func (c *chatPanel) show(open bool) {
+	c.open = open
+	if open {
+		c.unread = 0
+	}
+}
+
+// chatAvailable reports whether there is a peer to chat with.
+func (g *Game) chatAvailable() bool {
+	return g.playMode == HumanVsLAN && g.conn != nil &&
+		(g.state == StatePlaying || g.state == StateGameOver) &&
+		g.lanState != "reconnecting" && g.lanState != "peerLeft"
+}
+
+// chatWidth is how far the chat panel widens the window.
+func (g *Game) chatWidth() int {
+	if g.chat.open && g.chatAvailable() {
+		return ChatWidth
+	}
+	return 0
+}
+
+// cursorInChat reports whether the mouse is over the chat panel, where
+// clicks are not meant for the board.
+func (g *Game) cursorInChat() bool {
+	x, _ := ebiten.CursorPosition()
+	return x >= WindowWidth && g.chatWidth() > 0
+}
+
+// fitWindow widens the window for the chat panel and narrows it again.
+func (g *Game) fitWindow() {
+	if w := WindowWidth + g.chatWidth(); w != g.chat.width {
+		g.chat.width = w
+		ebiten.SetWindowSize(w, WindowHeight)
+	}
+}
+
+// promptUp reports whether a Y/N prompt is waiting, which the keyboard
+// answers before it types any chat.
+func (g *Game) promptUp() bool {
+	return g.undoPending && !g.undoRequested || g.confirmResign || g.offerReceived != noOffer
+}
+
+// keyJustPressed is inpututil.IsKeyJustPressed for the game's shortcuts,
+// which do not fire while the keyboard types into the chat.
+func (g *Game) keyJustPressed(key ebiten.Key) bool {
+	return !g.chat.keys && inpututil.IsKeyJustPressed(key)
+}
+
+// keyRepeated reports a key just pressed, or held long enough to repeat.
+func keyRepeated(key ebiten.Key) bool {
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || d >= 30 && d%3 == 0
+}
+
+// updateChat takes the keys for the chat: Tab shows or hides it, Enter
+// starts and sends a line, Escape drops it, and the quick phrase keys send
+// theirs.
+func (g *Game) updateChat() {
+	c := &g.chat
+	c.keys = false
+	if !g.chatAvailable() {
+		c.typing = false
+		return
+	}
+	if g.promptUp() {
+		return
+	}
+	if !c.typing {
+		if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+			c.show(!c.open)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+			c.show(true)
+			c.typing, c.keys = true, true
+		}
+		for _, q := range quickPhrases {
+			if inpututil.IsKeyJustPressed(q.key) {
+				g.say(q.text)
+			}
+		}
+		return
+	}
+
+	c.keys = true
+	c.draft = ebiten.AppendInputChars(c.draft)
+	if len(c.draft) > maxChatLen {
+		c.draft = c.draft[:maxChatLen]
+	}
+	if keyRepeated(ebiten.KeyBackspace) && len(c.draft) > 0 {
+		c.draft = c.draft[:len(c.draft)-1]
+	}
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+		g.say(string(c.draft))
+		c.draft, c.typing = nil, false
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		c.draft, c.typing = nil, false
+	}
+}
+
+// say sends a line of chat to the peer and shows it.
+func (g *Game) say(s string) {
+	s = cleanChat(s)
+	if s == "" {
+		return
+	}
+	sendChat(g.conn, s)
+	g.chat.add(chatLine{at: time.Now(), from: "You", text: s, mine: true})
+}
+
+// hear shows a line of chat from the peer.
+func (g *Game) hear(s string) {
+	if s != "" {
+		g.chat.add(chatLine{at: time.Now(), from: g.conn.peer, text: s})
+	}
+}
+
+func (g *Game) drawChat(screen *ebiten.Image) {
+	if g.chatWidth() == 0 {
+		return
+	}
+	const (
+		scale = 0.5
+		pad   = 10.0
+	)
+	x0 := float64(WindowWidth)
+	width := float64(ChatWidth) - pad*2
+	lineH := float64(utils.MplusFont.Metrics().Height>>6) * scale
+	ebitenutil.DrawRect(screen, x0, 0, ChatWidth, WindowHeight, color.RGBA{190, 160, 120, 255})
+
+	draw := func(s string, y float64, clr color.Color) {
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(scale, scale)
+		op.GeoM.Translate(x0+pad, y)
+		op.ColorM.ScaleWithColor(clr)
+		text.DrawWithOptions(screen, s, utils.MplusFont, op)
+	}
+	// The game's LAN lines head the panel, off the board.
+	header := []string{
+		"Chat  (Tab: hide)",
+		fmt.Sprintf("Opponent: %s (%v)", g.conn.peer, g.mySide().Other()),
+		"R: Resign  D: Offer draw",
+	}
+	if g.syncing {
+		header = append(header, "Resyncing with the host...")
+	}
+	for i, s := range header {
+		draw(s, pad+lineH*float64(i+1), color.Black)
+	}
+
+	// The line being typed, or how to start one.
+	input := []string{"Enter: type  F1-F4: quick phrases"}
+	switch {
+	case g.chat.typing && g.promptUp():
+		input = []string{"Answer the prompt first"}
+	case g.chat.typing:
+		input = wrapText(string(g.chat.draft)+"_", width, scale)
+	}
+	boxH := lineH*float64(len(input)) + pad
+	boxY := float64(WindowHeight) - pad - boxH
+	ebitenutil.DrawRect(screen, x0+pad/2, boxY, ChatWidth-pad, boxH, color.RGBA{230, 210, 180, 255})
+	for i, s := range input {
+		draw(s, boxY+lineH*float64(i+1), color.Black)
+	}
+
+	// The history fills upwards from the input, newest last.
+	y := boxY - pad/2
+	top := pad + lineH*float64(len(header)+1)
+	for i := len(g.chat.lines) - 1; i >= 0 && y > top; i-- {
+		l := g.chat.lines[i]
+		clr := color.Color(color.Black)
+		if l.mine {
+			clr = color.RGBA{60, 40, 120, 255}
+		}
+		wrapped := wrapText(fmt.Sprintf("%s %s: %s", l.at.Format("15:04"), l.from, l.text), width, scale)
+		for j := len(wrapped) - 1; j >= 0 && y > top; j-- {
+			draw(wrapped[j], y, clr)
+			y -= lineH
+		}
+	}
+}
+
+// wrapText breaks s into lines no wider than width once drawn at scale,
+// between words where it can.
+func wrapText(s string, width, scale float64) []string {
+	fits := func(t string) bool {
+		return float64(text.BoundString(utils.MplusFont, t).Dx())*scale <= width
+	}
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if try := strings.TrimSpace(line + " " + word); fits(try) {
+			line = try
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		// A word wider than a line is broken where it must.
+		for len([]rune(word)) > 1 && !fits(word) {
+			r := []rune(word)
+			n := len(r) - 1
+			for n > 1 && !fits(string(r[:n])) {
+				n--
+			}
+			lines = append(lines, string(r[:n]))
+			word = string(r[n:])
+		}
+		line = word
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
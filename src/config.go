@@ -1,16 +1,23 @@
 package src
 
-import "image/color"
+import (
+	"image/color"
+
+	"wuziqi/src/rules"
+)
 
 const (
-	BoardSize    = 8
-	TileSize     = 40
-	Margin       = TileSize
-	BoardWidth   = TileSize * (BoardSize - 1)
+	// MaxBoardSize is the largest board the game and engines handle.
+	MaxBoardSize = rules.MaxSize
+	// The board is drawn BoardWidth across whatever its size, so the tiles
+	// shrink as the board grows.
+	Margin       = 40
+	BoardWidth   = 480
 	WindowWidth  = BoardWidth + Margin*2
 	StatusHeight = 60
 	WindowHeight = WindowWidth + StatusHeight
-	StoneRadius  = float64(TileSize) / 2 * 0.9
+	// The window widens by ChatWidth while the LAN chat is shown.
+	ChatWidth = 240
 )
 
 var (
@@ -18,12 +25,14 @@ var (
 	OverlayColor = color.RGBA{R: 0, G: 0, B: 0, A: 128}
 )
 
-type Stone int
+// Stone is the colour on a point; the rules package defines it so that the
+// board, the engines and the referee agree.
+type Stone = rules.Stone
 
 const (
-	Empty Stone = iota
-	Black
-	White
+	Empty = rules.Empty
+	Black = rules.Black
+	White = rules.White
 )
 
 type GameState int
@@ -35,6 +44,8 @@ const (
 	StateGameOver
 	StateDifficultySelect
 	StateLANConnect
+	StateSettings
+	StateNewGame
 )
 
 type PlayMode int
@@ -51,4 +62,6 @@ const (
 	Easy DifficultyLevel = iota
 	Medium
 	Hard
+	Expert
+	Custom
 )
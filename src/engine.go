@@ -0,0 +1,206 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"wuziqi/src/rules"
+)
+
+// Engine is a computer player. The game reports every move and undo to it,
+// so engines that keep state between turns stay in step with the board.
+type Engine interface {
+	Name() string
+	Options() map[string]string
+	SetOption(name, value string) error
+
+	// NewGame starts a game of v. Engines that cannot play v return an
+	// error. Engines place stones in turn and leave v's opening to the
+	// caller, which makes any choice of colour with openingChoice.
+	NewGame(v rules.Variant) error
+	Play(row, col int) error
+	Undo() error
+
+	// GenMove returns a move for the side to play without playing it.
+	// A deadline on ctx is a time budget: the engine answers with the best
+	// move found so far. Cancelling ctx abandons the search with an error.
+	GenMove(ctx context.Context) (row, col int, err error)
+
+	Close()
+}
+
+// StatsReporter is implemented by engines that can report on their last
+// search.
+type StatsReporter interface {
+	Stats() SearchStats
+}
+
+// VariantChecker is implemented by engines that play only some variants,
+// so callers can offer another engine before starting a game.
+type VariantChecker interface {
+	Supports(v rules.Variant) bool
+}
+
+// EngineSupports reports whether e can play v.
+func EngineSupports(e Engine, v rules.Variant) bool {
+	c, ok := e.(VariantChecker)
+	return !ok || c.Supports(v)
+}
+
+// Analysis is an engine's view of the position after its last search.
+type Analysis struct {
+	Score int      // for the engine, in the engine's own units
+	Depth int      // plies searched, not counting forced replies
+	PV    [][2]int // principal variation, starting with the move returned
+}
+
+// Analyzer is implemented by engines that report a score and principal
+// variation for their last search.
+type Analyzer interface {
+	Analysis() Analysis
+}
+
+func formatMoves(moves [][2]int) string {
+	parts := make([]string, len(moves))
+	for i, m := range moves {
+		parts[i] = fmt.Sprintf("%d,%d", m[0], m[1])
+	}
+	return strings.Join(parts, " ")
+}
+
+var engineRegistry = map[string]func() Engine{}
+
+// RegisterEngine makes an engine available to NewEngine under name.
+func RegisterEngine(name string, factory func() Engine) {
+	engineRegistry[name] = factory
+}
+
+func NewEngine(name string) (Engine, error) {
+	factory, ok := engineRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown engine %q", name)
+	}
+	return factory(), nil
+}
+
+func EngineNames() []string {
+	names := make([]string, 0, len(engineRegistry))
+	for name := range engineRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DifficultyEngines names the engine played at each difficulty level.
+// Custom plays the MCTS with the profile from the settings screen.
+var DifficultyEngines = map[DifficultyLevel]string{
+	Easy:   "mcts-easy",
+	Medium: "mcts-medium",
+	Hard:   "alphazero",
+	Expert: "mcts-expert",
+}
+
+var errUnknownOption = errors.New("unknown option")
+
+// enginePosition follows the game for engines that search from the
+// current board. Embedding it provides NewGame, Play and Undo; board mirrors
+// pos for the searches.
+type enginePosition struct {
+	pos   *rules.Position
+	board Board
+}
+
+func newEnginePosition() enginePosition {
+	return enginePosition{pos: rules.NewPosition(rules.Standard), board: NewBoard(rules.Standard)}
+}
+
+func (p *enginePosition) NewGame(v rules.Variant) error {
+	if err := v.Validate(); err != nil {
+		return err
+	}
+	v.Opening = rules.NoOpening
+	p.pos = rules.NewPosition(v)
+	p.board = NewBoard(v)
+	return nil
+}
+
+func (p *enginePosition) Play(row, col int) error {
+	s := p.pos.ToMove()
+	if err := p.pos.Play(row, col); err != nil {
+		return err
+	}
+	p.board.Play(row, col, s)
+	return nil
+}
+
+func (p *enginePosition) Undo() error {
+	if err := p.pos.Undo(); err != nil {
+		return err
+	}
+	// The move may have captured stones, which come back with it.
+	p.board = BoardOf(p.pos)
+	return nil
+}
+
+// forcedWin returns the line of a forced win for the side to move, if the
+// threat solver finds one within DefaultThreatLimits.
+func (p *enginePosition) forcedWin(ctx context.Context) ([][2]int, bool) {
+	res, _ := ForcedWin(ctx, p.board, p.pos.ToMove(), DefaultThreatLimits)
+	return res.Line, res.Win
+}
+
+// openingChoice picks a colour for the computer at an opening choice in
+// pos: White, which moves next, unless the stones look better for Black.
+// It never asks to place two more stones, which would leave the engine to
+// balance an opening it does not know.
+func openingChoice(pos *rules.Position) rules.Choice {
+	b := BoardOf(pos)
+	if evaluate(&b, White) >= 0 {
+		return rules.TakeWhite
+	}
+	return rules.TakeBlack
+}
+
+// PlayMatch plays one game of v between two engines with budget per move
+// and returns the winner, or Empty for a draw. Engines do not place opening
+// stones, so v must have no opening.
+func PlayMatch(black, white Engine, v rules.Variant, budget time.Duration) (Stone, error) {
+	if v.Opening != rules.NoOpening {
+		return Empty, fmt.Errorf("engine matches have no %v opening", v.Opening)
+	}
+	players := map[Stone]Engine{Black: black, White: white}
+	engines := []Engine{black}
+	if white != black {
+		engines = append(engines, white)
+	}
+	for _, e := range engines {
+		if err := e.NewGame(v); err != nil {
+			return Empty, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+	}
+
+	pos := rules.NewPosition(v)
+	for pos.Result() == rules.Ongoing {
+		player := players[pos.ToMove()]
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		row, col, err := player.GenMove(ctx)
+		cancel()
+		if err != nil {
+			return Empty, fmt.Errorf("%s: %w", player.Name(), err)
+		}
+		if err := pos.Play(row, col); err != nil {
+			return Empty, fmt.Errorf("%s: %w", player.Name(), err)
+		}
+		for _, e := range engines {
+			if err := e.Play(row, col); err != nil {
+				return Empty, fmt.Errorf("%s: %w", e.Name(), err)
+			}
+		}
+	}
+	return pos.Result().Winner(), nil
+}
@@ -2,16 +2,19 @@ package src
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"image/color"
 	"io/fs"
 	"log"
 	"math"
 	"math/rand"
-	"net"
 	"os"
+	"strings"
 	"time"
+	"wuziqi/src/rules"
 	"wuziqi/utils"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -29,40 +32,80 @@ var stoneBytes []byte
 //go:embed assets/bgm/*.mp3
 var bgmFS embed.FS // Embeds the BGM folder
 
+// threatHint answers the forced-win question for the position after the
+// given number of moves.
+type threatHint struct {
+	moves int
+	text  string
+}
+
+// aiMove is the outcome of a background engine search. moves is the
+// position's move count when the search started.
+type aiMove struct {
+	row, col int
+	moves    int
+	err      error
+}
+
 type Game struct {
-	board            [BoardSize][BoardSize]Stone
-	currentTurn      Stone
-	winner           Stone
-	moves            int
-	state            GameState
-	playMode         PlayMode
-	difficulty       DifficultyLevel
-	moveHistory      [][2]int
-	pendingAI        bool
-	conn             net.Conn
-	role             string
-	lanState         string
-	foundRooms       []RoomInfo
-	selectedIdx      int
-	lanReceivedMoves chan [2]int
-	undoRequested    bool
-	undoPending      bool
-	undoResponseCh   chan bool
-	lastMover        Stone
-	audioContext *audio.Context
-	bgmPlayer    *audio.Player
-	stonePlayer  *audio.Player
-	masterVolume float64
-	rand         *rand.Rand // ADD THIS
+	pos           *rules.Position
+	variant       rules.Variant
+	newGameMode   PlayMode
+	newGameIdx    int
+	state         GameState
+	playMode      PlayMode
+	difficulty    DifficultyLevel
+	pendingAI     bool
+	aiCancel      context.CancelFunc
+	aiResult      chan aiMove
+	aiStarted     time.Time
+	conn          *lanConn
+	role          string
+	lanState      string
+	lanErr        error
+	foundRooms    []RoomInfo
+	selectedIdx   int
+	lanEvents     chan netEvent
+	lanOutgoing   [][2]int
+	syncing       bool      // waiting for the host's game after a desync
+	reconnectBy   time.Time // when a dropped game stops waiting
+	undoRequested bool
+	undoPending   bool
+	offerSent     offer    // waiting for the peer to answer it
+	offerReceived offer    // the peer waiting for an answer
+	confirmResign bool     // asking whether to resign
+	listening     *lanConn // the connection listenLAN reads
+	chat          chatPanel
+	lastMover     rules.Side
+	engine        Engine
+	aiErr         error
+	hardEngine    string
+	customProfile SearchProfile
+	settingsIdx   int
+	hint          threatHint
+	hintResult    chan threatHint
+	hintCancel    context.CancelFunc
+	forbidden     [][2]int
+	forbiddenKey  uint64
+	savedAs       string
+	audioContext  *audio.Context
+	bgmPlayer     *audio.Player
+	stonePlayer   *audio.Player
+	masterVolume  float64
+	rand          *rand.Rand // ADD THIS
 }
 
 func NewGame() *Game {
 	utils.InitFont()
 	g := &Game{
-		state:            StateModeSelect,
-		lanReceivedMoves: make(chan [2]int, 10),
-		masterVolume:     0.5,
-		rand:             rand.New(rand.NewSource(time.Now().UnixNano())), // Initialize random source
+		pos:           rules.NewPosition(rules.Standard),
+		variant:       rules.Standard,
+		state:         StateModeSelect,
+		lanEvents:     make(chan netEvent, 16),
+		masterVolume:  0.5,
+		hardEngine:    DifficultyEngines[Hard],
+		customProfile: Profile(Custom),
+		rand:          rand.New(rand.NewSource(time.Now().UnixNano())), // Initialize random source
 	}
 	g.initAudio()
 	g.playNewRandomBGM() // Play the first random BGM at launch
@@ -135,68 +178,85 @@ func (g *Game) playNewRandomBGM() {
 
 func (g *Game) Reset(mode PlayMode) {
 	fmt.Printf("[RESET] mode=%v role=%v conn=%v\n", mode, g.role, g.conn != nil)
-	g.board = [BoardSize][BoardSize]Stone{}
-	g.currentTurn = Black
-	g.winner = Empty
-	g.moves = 0
+	g.pos = rules.NewPosition(g.variant)
 	g.playMode = mode
 	g.state = StatePlaying
-	g.moveHistory = nil
-	g.pendingAI = false
-
-	if mode == HumanVsLAN && g.conn != nil {
-		g.lanReceivedMoves = make(chan [2]int, 10)
-
-		g.undoResponseCh = make(chan bool, 1)
+	g.savedAs = ""
+	g.lanOutgoing = nil
+	g.cancelAISearch()
+	g.cancelThreatHint()
+	g.aiErr = nil
+	if mode != HumanVsAI {
+		g.closeEngine()
+	}
+	if g.engine != nil {
+		g.aiErr = g.engine.NewGame(g.variant)
+	}
 
-		go func() {
-			for {
-				if g.conn == nil {
-					return
-				}
-				row, col, op, err := recvMessage(g.conn)
-				if err != nil {
-					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-						continue
-					}
-					fmt.Println("recvMessage error:", err)
-					g.lanState = "failed"
-					return
-				}
-				switch op {
-				case "MOVE":
-					g.lanReceivedMoves <- [2]int{row, col}
-				case "UNDO_REQUEST":
-					g.undoPending = true
-					g.undoRequested = false
-				case "UNDO_ACCEPT":
-					g.undoResponseCh <- true
-				case "UNDO_REJECT":
-					g.undoResponseCh <- false
-				case "PEER_LEFT":
-					g.lanState = "peerLeft"
-				}
-			}
-		}()
+	g.syncing = false
+	g.undoPending, g.undoRequested = false, false
+	g.offerSent, g.offerReceived, g.confirmResign = noOffer, noOffer, false
+	// A rematch keeps the connection and the reader on it.
+	if mode == HumanVsLAN && g.conn != nil && g.listening != g.conn {
+		g.lanEvents = make(chan netEvent, 16)
+		g.listenLAN(g.conn, g.role)
 	}
     g.playNewRandomBGM()
 }
 
+// listenLAN passes the messages from conn to the game until it drops, and
+// then a DISCONNECTED from conn, which the game ignores if it has moved on
+// to another connection. role names this end in the log. The reader only
+// touches what it is given, never the game, and stops when the session
+// ends.
+func (g *Game) listenLAN(conn *lanConn, role string) {
+	g.listening = conn
+	events := g.lanEvents
+	deliver := func(ev netEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-conn.done:
+			return false
+		}
+	}
+	go func() {
+		for {
+			ev, err := recvMessage(conn)
+			if errors.Is(err, errUnknownMessage) || errors.Is(err, errBadMessage) {
+				log.Printf("[RECV] %s skipped: %v", role, err)
+				continue
+			}
+			if err != nil {
+				log.Printf("[RECV] %s lost the connection: %v", role, err)
+				deliver(netEvent{op: "DISCONNECTED", from: conn})
+				return
+			}
+			if !deliver(ev) {
+				return
+			}
+		}
+	}()
+}
+
 func (g *Game) Update() error {
+	g.updateChat()
+	g.fitWindow()
 	switch g.state {
 	case StateModeSelect:
 		if g.conn != nil {
+			g.conn.end()
 			_ = g.conn.Close()
 		}
 		g.conn = nil
+		g.chat.clear()
 		g.role = ""
 		g.lanState = ""
+		g.lanErr = nil
 		g.foundRooms = nil
-		g.board = [BoardSize][BoardSize]Stone{}
-		g.currentTurn = Black
-		g.winner = Empty
-		g.moves = 0
-		g.moveHistory = nil
+		g.pos = rules.NewPosition(g.variant)
+		g.aiErr = nil
+		g.closeEngine()
 		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
 			_, y := ebiten.CursorPosition()
 
@@ -207,11 +267,11 @@ func (g *Game) Update() error {
 
 			switch {
 			case y >= startY && y < startY+itemHeight:
-				g.Reset(HumanVsHuman)
+				g.openNewGame(HumanVsHuman)
 			case y >= startY+spacing && y < startY+spacing+itemHeight:
-				g.state = StateDifficultySelect
+				g.openNewGame(HumanVsAI)
 			case y >= startY+2*spacing && y < startY+2*spacing+itemHeight:
-				g.state = StateLANConnect
+				g.openNewGame(HumanVsLAN)
 			case y >= startY+3*spacing && y < startY+3*spacing+itemHeight:
 				os.Exit(0)
 			}
@@ -222,31 +282,44 @@ func (g *Game) Update() error {
 			_, y := ebiten.CursorPosition()
 
 			centerY := WindowHeight / 2
-			spacing := 60
+			spacing := 45
 			itemHeight := 32
-			startY := centerY - spacing*2 + spacing*1
+			startY := centerY - spacing*3 + spacing*1
 
-			if y >= startY && y < startY+itemHeight {
-				g.difficulty = Easy
-				g.Reset(HumanVsAI)
-			} else if y >= startY+spacing && y < startY+spacing+itemHeight {
-				g.difficulty = Medium
-				g.Reset(HumanVsAI)
-			} else if y >= startY+2*spacing && y < startY+2*spacing+itemHeight {
-				g.difficulty = Hard
-				g.Reset(HumanVsAI)
+			switch {
+			case y >= startY && y < startY+itemHeight:
+				g.startAIGame(Easy)
+			case y >= startY+spacing && y < startY+spacing+itemHeight:
+				g.startAIGame(Medium)
+			case y >= startY+2*spacing && y < startY+2*spacing+itemHeight:
+				g.startAIGame(Hard)
+			case y >= startY+3*spacing && y < startY+3*spacing+itemHeight:
+				g.startAIGame(Expert)
+			case y >= startY+4*spacing && y < startY+4*spacing+itemHeight:
+				g.startAIGame(Custom)
+			case y >= startY+5*spacing && y < startY+5*spacing+itemHeight:
+				g.state = StateSettings
 			}
 		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			g.state = StateNewGame
+		}
+
+	case StateSettings:
+		g.updateSettings()
+
+	case StateNewGame:
+		g.updateNewGame()
 
 	case StatePlaying:
 				volumeChanged := false
 		// Check for volume up keys
-		if inpututil.IsKeyJustPressed(ebiten.KeyNumpadAdd) || inpututil.IsKeyJustPressed(ebiten.KeyEqual) {
+		if g.keyJustPressed(ebiten.KeyNumpadAdd) || g.keyJustPressed(ebiten.KeyEqual) {
 			g.masterVolume += 0.1
 			volumeChanged = true
 		}
 		// Check for volume down keys
-		if inpututil.IsKeyJustPressed(ebiten.KeyNumpadSubtract) || inpututil.IsKeyJustPressed(ebiten.KeyMinus) {
+		if g.keyJustPressed(ebiten.KeyNumpadSubtract) || g.keyJustPressed(ebiten.KeyMinus) {
 			g.masterVolume -= 0.1
 			volumeChanged = true
 		}
@@ -276,73 +349,104 @@ func (g *Game) Update() error {
 				g.stonePlayer.SetVolume(g.masterVolume)
 			}
 		}
-		if g.playMode == HumanVsAI && g.currentTurn == White && !g.pendingAI && g.winner == Empty {
-			g.pendingAI = true
+		if g.playMode == HumanVsAI && g.aiToAct() && !g.pendingAI && g.pos.Result() == rules.Ongoing && g.aiErr == nil {
+			if len(g.pos.Pending()) > 0 {
+				g.decide(openingChoice(g.pos))
+			} else {
+				g.startAISearch()
+			}
 		}
 
 		if g.pendingAI {
-			var row, col int
-			if g.difficulty == Hard {
-				row, col = GetAIMove(g.board)
-			} else {
-				row, col = GetMCTMove(g.board, g.currentTurn, g.difficulty)
+			select {
+			case res := <-g.aiResult:
+				g.finishAISearch(res)
+				return nil
+			default:
 			}
-			g.placeStoneAt(row, col)
-			g.pendingAI = false
-			return nil
 		}
 
-		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		if g.keyJustPressed(ebiten.KeyF) && g.hintResult == nil && g.pos.Result() == rules.Ongoing &&
+			threatsApply(g.pos.Variant().Rule) {
+			g.startThreatHint()
+		}
+		if g.hintResult != nil {
+			select {
+			case h := <-g.hintResult:
+				g.hintCancel()
+				g.hintResult, g.hintCancel = nil, nil
+				g.hint = h
+			default:
+			}
+		}
+
+		if g.keyJustPressed(ebiten.KeyEscape) {
+			g.cancelAISearch()
+			g.cancelThreatHint()
 			g.cleanupLAN()
 			g.state = StateModeSelect
 			return nil
 		}
 
-		if g.playMode == HumanVsLAN {
-			isMyTurn := (g.role == "host" && g.currentTurn == Black) ||
-				(g.role == "client" && g.currentTurn == White)
+		if len(g.pos.Pending()) > 0 && g.localTurn() && !g.undoPending {
+			g.updateChoice()
+		}
 
-			if isMyTurn {
-				if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
-					g.handlePlayerMove()
+		if g.playMode == HumanVsLAN {
+			select {
+			case c := <-g.conn.rejoins:
+				g.resume(c)
+			default:
+			}
+			switch g.lanState {
+			case "reconnecting":
+				if time.Now().After(g.reconnectBy) {
+					log.Printf("[LAN] %s gave up waiting for the peer", g.role)
+					g.lanState = "peerLeft"
+					g.conn.end()
 				}
-			} else {
-				select {
-				case move := <-g.lanReceivedMoves:
-					g.board[move[0]][move[1]] = g.currentTurn
-					g.moveHistory = append(g.moveHistory, move)
-					g.moves++
-					if g.checkWin(move[0], move[1]) {
-						g.winner = g.currentTurn
-						g.state = StateGameOver
-					} else if g.moves == BoardSize*BoardSize {
-						g.winner = Empty
-						g.state = StateGameOver
-					} else {
-						g.currentTurn = 3 - g.currentTurn
-					}
-					fmt.Printf("[RECV] %s received: (%d,%d)\n", g.role, move[0], move[1])
-				default:
+				return nil
+			case "peerLeft":
+				if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+					g.cancelThreatHint()
+					g.cleanupLAN()
+					g.state = StateModeSelect
 				}
+				return nil
 			}
-			if g.undoPending && g.undoRequested {
-				select {
-				case accept := <-g.undoResponseCh:
-					g.undoPending = false
-					if accept {
-						g.undoLastMove()
-					}
-				default:
+			select {
+			case ev := <-g.lanEvents:
+				g.applyLANEvent(ev)
+			default:
+			}
+			if g.state != StatePlaying || g.syncing || g.undoPending && g.undoRequested {
+				return nil
+			}
+			if !g.undoPending {
+				if g.updateOffers() {
+					return nil
+				}
+				if g.keyJustPressed(ebiten.KeyR) {
+					g.confirmResign = true
+					return nil
+				}
+				if g.keyJustPressed(ebiten.KeyD) {
+					g.makeOffer(drawOffer)
 					return nil
 				}
 			}
+			if g.localTurn() {
+				if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+					g.handlePlayerMove()
+				}
+			}
 
 			if g.undoPending && !g.undoRequested {
-				if inpututil.IsKeyJustPressed(ebiten.KeyY) {
-					sendUndoAccept(g.conn)
+				if g.keyJustPressed(ebiten.KeyY) {
+					sendUndoAccept(g.conn, g.pos)
 					g.undoLastMove()
 					g.undoPending = false
-				} else if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+				} else if g.keyJustPressed(ebiten.KeyN) {
 					sendUndoReject(g.conn)
 					g.undoPending = false
 				}
@@ -352,12 +456,12 @@ func (g *Game) Update() error {
 
 		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
 			x, y := ebiten.CursorPosition()
-			if x >= WindowWidth-120 && y >= WindowHeight-50 {
+			if x >= WindowWidth-120 && x < WindowWidth && y >= WindowHeight-50 {
 				g.undoMove()
 				return nil
 			}
 
-			if g.playMode == HumanVsAI && g.currentTurn == White {
+			if g.playMode == HumanVsAI && g.aiToAct() {
 				return nil
 			}
 
@@ -366,12 +470,12 @@ func (g *Game) Update() error {
 
 		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
 			x, y := ebiten.CursorPosition()
-			if x >= WindowWidth-120 && y >= WindowHeight-50 {
+			if x >= WindowWidth-120 && x < WindowWidth && y >= WindowHeight-50 {
 				g.undoMove()
 				return nil
 			}
 
-			if g.playMode == HumanVsAI && g.currentTurn == White {
+			if g.playMode == HumanVsAI && g.aiToAct() {
 				return nil
 			}
 
@@ -382,38 +486,14 @@ func (g *Game) Update() error {
 		if inpututil.IsKeyJustPressed(ebiten.KeyH) && g.conn == nil {
 			g.lanState = "hosting"
 			go func() {
-				conn, err := HostGame()
+				conn, err := HostGame(g.variant)
 				if err != nil {
-					g.lanState = "failed"
+					g.lanState, g.lanErr = "failed", err
 					return
 				}
 				g.conn = conn
 				g.role = "host"
 				g.Reset(HumanVsLAN)
-
-				go func() {
-					for {
-						if g.conn == nil {
-							break
-						}
-						row, col, op, err := recvMessage(g.conn)
-						if err != nil {
-							g.lanState = "failed"
-							break
-						}
-						switch op {
-						case "MOVE":
-							g.lanReceivedMoves <- [2]int{row, col}
-						case "UNDO_REQUEST":
-							g.undoPending = true
-							g.undoRequested = false
-						case "UNDO_ACCEPT":
-							g.undoResponseCh <- true
-						case "UNDO_REJECT":
-							g.undoResponseCh <- false
-						}
-					}
-				}()
 			}()
 		}
 
@@ -436,36 +516,13 @@ func (g *Game) Update() error {
 				room := g.foundRooms[g.selectedIdx]
 				conn, err := JoinRoom(room)
 				if err != nil {
-					g.lanState = "failed"
+					g.lanState, g.lanErr = "failed", err
 					return nil
 				}
 				g.conn = conn
 				g.role = "client"
+				g.variant = conn.variant
 				g.Reset(HumanVsLAN)
-
-				go func() {
-					for {
-						if g.conn == nil {
-							break
-						}
-						row, col, op, err := recvMessage(g.conn)
-						if err != nil {
-							g.lanState = "failed"
-							break
-						}
-						switch op {
-						case "MOVE":
-							g.lanReceivedMoves <- [2]int{row, col}
-						case "UNDO_REQUEST":
-							g.undoPending = true
-							g.undoRequested = false
-						case "UNDO_ACCEPT":
-							g.undoResponseCh <- true
-						case "UNDO_REJECT":
-							g.undoResponseCh <- false
-						}
-					}
-				}()
 			}
 		}
 
@@ -479,40 +536,208 @@ func (g *Game) Update() error {
 		}
 
 	case StateGameOver:
-		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		if g.playMode == HumanVsLAN && g.conn != nil {
+			select {
+			case ev := <-g.lanEvents:
+				g.applyLANEvent(ev)
+			default:
+			}
+			// Waiting on our own offer still lets the player save or leave.
+			if g.state != StateGameOver || g.updateOffers() && g.offerSent == noOffer {
+				return nil
+			}
+		}
+		if g.keyJustPressed(ebiten.KeyS) && g.savedAs == "" {
+			g.saveGame()
+		}
+		if g.keyJustPressed(ebiten.KeyR) {
+			g.makeOffer(rematchOffer)
+			return nil
+		}
+		if g.keyJustPressed(ebiten.KeyC) && g.playMode == HumanVsLAN {
+			g.makeOffer(swapRematchOffer)
+			return nil
+		}
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && !g.cursorInChat() {
+			g.cancelThreatHint()
 			g.state = StateModeSelect
 		}
 	}
 	return nil
 }
 
+// saveGame writes the record of the finished game, rule included, to a
+// file in the working directory.
+func (g *Game) saveGame() {
+	name := "gomoku-" + time.Now().Format("20060102-150405") + ".txt"
+	if err := os.WriteFile(name, []byte(g.pos.Record()), 0o644); err != nil {
+		log.Println("save game:", err)
+		g.savedAs = "Save failed"
+		return
+	}
+	g.savedAs = "Saved to " + name
+}
+
 func (g *Game) handlePlayerMove() {
 	if g.undoPending {
 		return
 	}
 	x, y := ebiten.CursorPosition()
-	col := int(math.Round((float64(x) - Margin) / TileSize))
-	row := int(math.Round((float64(y) - Margin) / TileSize))
-	if row >= 0 && row < BoardSize && col >= 0 && col < BoardSize && g.board[row][col] == Empty {
+	row, col := g.pointAt(x, y)
+	if g.pos.Legal(row, col) {
 		g.placeStoneAt(row, col)
 	}
 }
 
-func (g *Game) placeStoneAt(row, col int) {
-	if g.board[row][col] != Empty {
+// fallbackEngine stands in for an engine that cannot play the chosen board.
+const fallbackEngine = "mcts-hard"
+
+// startAIGame starts a game against the engine registered for difficulty,
+// or the engine chosen in the settings for Hard and Custom.
+func (g *Game) startAIGame(difficulty DifficultyLevel) {
+	g.closeEngine()
+	g.difficulty = difficulty
+	var engine Engine
+	var err error
+	switch difficulty {
+	case Hard:
+		engine, err = NewEngine(g.hardEngine)
+	case Custom:
+		engine = NewMCTSEngine(g.customProfile)
+	default:
+		engine, err = NewEngine(DifficultyEngines[difficulty])
+	}
+	if err != nil {
+		log.Println("AI error:", err)
 		return
 	}
+	if !EngineSupports(engine, g.variant) {
+		log.Printf("%s does not play %v, using %s", engine.Name(), g.variant, fallbackEngine)
+		engine.Close()
+		if engine, err = NewEngine(fallbackEngine); err != nil {
+			log.Println("AI error:", err)
+			return
+		}
+	}
+	g.engine = engine
+	g.Reset(HumanVsAI)
+}
 
-	isMyTurn := (g.role == "host" && g.currentTurn == Black) ||
-		(g.role == "client" && g.currentTurn == White)
+// startAISearch runs the engine in the background so the window keeps
+// responding while it thinks. The move arrives on g.aiResult.
+func (g *Game) startAISearch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan aiMove, 1)
+	engine, moves := g.engine, g.pos.MoveCount()
+	go func() {
+		row, col, err := engine.GenMove(ctx)
+		result <- aiMove{row: row, col: col, moves: moves, err: err}
+	}()
+	g.aiCancel = cancel
+	g.aiResult = result
+	g.aiStarted = time.Now()
+	g.pendingAI = true
+}
 
-	if g.playMode == HumanVsLAN && !isMyTurn {
+func (g *Game) finishAISearch(res aiMove) {
+	g.aiCancel()
+	g.aiCancel = nil
+	g.aiResult = nil
+	g.pendingAI = false
+	if res.moves != g.pos.MoveCount() || g.state != StatePlaying {
+		return
+	}
+	if res.err != nil {
+		log.Println("AI error:", res.err)
+		g.aiErr = res.err
+		return
+	}
+	if r, ok := g.engine.(StatsReporter); ok {
+		st := r.Stats()
+		log.Printf("AI search: %d playouts, %d visits reused, %d at root, %d nodes", st.Playouts, st.ReusedVisits, st.RootVisits, st.Nodes)
+	}
+	if a, ok := g.engine.(Analyzer); ok {
+		an := a.Analysis()
+		log.Printf("AI analysis: depth %d, score %d, pv %s", an.Depth, an.Score, formatMoves(an.PV))
+	}
+	if err := g.pos.Check(res.row, res.col); err != nil {
+		g.aiErr = fmt.Errorf("engine played %d,%d: %w", res.row, res.col, err)
+		log.Println("AI error:", g.aiErr)
 		return
 	}
+	g.placeStoneAt(res.row, res.col)
+}
+
+// startThreatHint asks the threat solver in the background whether the
+// side to move has a forced win. The answer arrives on g.hintResult.
+func (g *Game) startThreatHint() {
+	ctx, cancel := context.WithCancel(context.Background())
+	board, toMove, moves := BoardOf(g.pos), g.pos.ToMove(), g.pos.MoveCount()
+	ch := make(chan threatHint, 1)
+	go func() {
+		limits := ThreatLimits{Nodes: 200000, TimeLimit: 2 * time.Second, Depth: 10}
+		res, kind := ForcedWin(ctx, board, toMove, limits)
+		ch <- threatHint{moves: moves, text: res.Describe(toMove, kind)}
+	}()
+	g.hintCancel = cancel
+	g.hintResult = ch
+}
+
+// cancelThreatHint stops a running threat solver and drops its answer. The
+// solver works on its own copy of the board, so it need not be waited for.
+func (g *Game) cancelThreatHint() {
+	if g.hintCancel != nil {
+		g.hintCancel()
+	}
+	g.hintCancel = nil
+	g.hintResult = nil
+	g.hint = threatHint{}
+}
+
+// cancelAISearch stops a running search and waits for it to return, so the
+// engine is idle and its result is discarded before the position changes.
+func (g *Game) cancelAISearch() {
+	if g.aiCancel != nil {
+		g.aiCancel()
+		<-g.aiResult
+	}
+	g.aiCancel = nil
+	g.aiResult = nil
+	g.pendingAI = false
+}
 
-	g.board[row][col] = g.currentTurn
-	g.moveHistory = append(g.moveHistory, [2]int{row, col})
-	g.moves++
+func (g *Game) closeEngine() {
+	g.cancelAISearch()
+	if g.engine != nil {
+		g.engine.Close()
+		g.engine = nil
+	}
+}
+
+// Close releases resources that outlive the window, such as the AI worker.
+func (g *Game) Close() {
+	g.closeEngine()
+}
+
+func (g *Game) placeStoneAt(row, col int) {
+	if !g.pos.Legal(row, col) {
+		return
+	}
+
+	if g.playMode == HumanVsLAN && !g.localTurn() {
+		return
+	}
+
+	mover := g.pos.Turn()
+	if err := g.pos.Play(row, col); err != nil {
+		log.Println("move error:", err)
+		return
+	}
+	if g.engine != nil {
+		if err := g.engine.Play(row, col); err != nil {
+			g.aiErr = err
+		}
+	}
 
 	// --- Play sound effect ---
 	if g.stonePlayer != nil {
@@ -523,50 +748,24 @@ func (g *Game) placeStoneAt(row, col int) {
 	// --- End of sound effect ---
 
 	if g.playMode == HumanVsLAN && g.conn != nil {
-		sendMove(g.conn, row, col)
-		fmt.Printf("[SEND] %s sent: (%d,%d)\n", g.role, row, col)
+		// Hold the stones of a turn until it is over and send them as one.
+		g.lanOutgoing = append(g.lanOutgoing, [2]int{row, col})
+		if g.pos.Turn() != mover || g.pos.Result() != rules.Ongoing {
+			sendMoves(g.conn, g.lanOutgoing, g.pos)
+			log.Printf("[SEND] %s sent: %s", g.role, formatMoves(g.lanOutgoing))
+			g.lanOutgoing = nil
+		}
 	}
-	g.lastMover = g.currentTurn
-	if g.checkWin(row, col) {
-		g.winner = g.currentTurn
-		g.state = StateGameOver
-	} else if g.moves == BoardSize*BoardSize {
-		g.winner = Empty
+	g.lastMover = mover
+	if g.pos.Result() != rules.Ongoing {
 		g.state = StateGameOver
-	} else {
-		g.currentTurn = 3 - g.currentTurn
 	}
 }
 
-func (g *Game) checkWin(row, col int) bool {
-	dirs := [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}}
-	for _, d := range dirs {
-		count := 1
-		for i := 1; i < 5; i++ {
-			r, c := row+d[0]*i, col+d[1]*i
-			if r < 0 || r >= BoardSize || c < 0 || c >= BoardSize || g.board[r][c] != g.currentTurn {
-				break
-			}
-			count++
-		}
-		for i := 1; i < 5; i++ {
-			r, c := row-d[0]*i, col-d[1]*i
-			if r < 0 || r >= BoardSize || c < 0 || c >= BoardSize || g.board[r][c] != g.currentTurn {
-				break
-			}
-			count++
-		}
-		if count >= 5 {
-			return true
-		}
-	}
-	return false
-}
-
 func (g *Game) drawDifficultySelect(screen *ebiten.Image) {
 	centerX := WindowWidth / 2
 	centerY := WindowHeight / 2
-	spacing := 60
+	spacing := 45
 	itemHeight := 32
 
 	menuItems := []string{
@@ -574,12 +773,15 @@ func (g *Game) drawDifficultySelect(screen *ebiten.Image) {
 		"[1] Easy",
 		"[2] Medium",
 		"[3] Hard",
+		"[4] Expert",
+		"[5] Custom",
+		"Settings",
 	}
 
 	for i, item := range menuItems {
 		bounds := text.BoundString(utils.MplusFont, item)
 		x := centerX - bounds.Dx()/2
-		y := centerY - spacing*2 + i*spacing + itemHeight/2
+		y := centerY - spacing*3 + i*spacing + itemHeight/2
 
 		col := color.White
 		text.Draw(screen, item, utils.MplusFont, x, y, col)
@@ -594,118 +796,310 @@ func (g *Game) Draw(screen *ebiten.Image) {
 		g.drawModeSelect(screen)
 	case StateDifficultySelect:
 		g.drawDifficultySelect(screen)
+	case StateSettings:
+		g.drawSettings(screen)
+	case StateNewGame:
+		g.drawNewGame(screen)
 	case StateLANConnect:
 		g.drawLANConnect(screen)
 	case StatePlaying:
 		g.drawBoard(screen)
 		g.drawStatus(screen)
-		if g.undoPending {
+		if g.playMode == HumanVsLAN && g.lanState == "peerLeft" {
 			ebitenutil.DrawRect(screen, 0, 0,
 				float64(WindowWidth), float64(WindowHeight),
 				color.RGBA{0, 0, 0, 180})
-
-			drawSmallCenter := func(lines []string) {
-				scale := 0.5
-				lineH := int(float64(utils.MplusFont.Metrics().Height>>6) * scale)
-				totalH := lineH * len(lines)
-				startY := (WindowHeight - totalH) / 2
-
-				for i, txt := range lines {
-					b := text.BoundString(utils.MplusFont, txt)
-					img := ebiten.NewImage(b.Dx(), b.Dy())
-					img.Fill(color.Transparent)
-					text.Draw(img, txt, utils.MplusFont, 0, b.Dy(), color.White)
-
-					op := &ebiten.DrawImageOptions{}
-					op.GeoM.Scale(scale, scale)
-					op.GeoM.Translate(
-						float64(WindowWidth/2)-float64(b.Dx())*scale/2,
-						float64(startY+i*lineH),
-					)
-					screen.DrawImage(img, op)
-				}
-			}
-			if g.playMode == HumanVsLAN && g.lanState == "peerLeft" {
-				ebitenutil.DrawRect(screen, 0, 0,
-					float64(WindowWidth), float64(WindowHeight),
-					color.RGBA{0, 0, 0, 180})
-				drawSmallCenter([]string{
-					"Opponent has left the game",
-					"Click anywhere to return to menu",
+			g.drawSmallCenter(screen, []string{
+				"Opponent has left the game",
+				"Click anywhere to return to menu",
+			})
+		} else if g.playMode == HumanVsLAN && g.lanState == "reconnecting" {
+			ebitenutil.DrawRect(screen, 0, 0,
+				float64(WindowWidth), float64(WindowHeight),
+				color.RGBA{0, 0, 0, 180})
+			left := int(math.Ceil(time.Until(g.reconnectBy).Seconds()))
+			g.drawSmallCenter(screen, []string{
+				fmt.Sprintf("Opponent disconnected — waiting %d seconds", max(left, 0)),
+				"ESC: Menu",
+			})
+		} else if !g.undoPending && (g.confirmResign || g.offerReceived != noOffer || g.offerSent != noOffer) {
+			ebitenutil.DrawRect(screen, 0, 0,
+				float64(WindowWidth), float64(WindowHeight),
+				color.RGBA{0, 0, 0, 180})
+			switch {
+			case g.confirmResign:
+				g.drawSmallCenter(screen, []string{
+					"Resign this game?",
+					"Press [Y] to resign  |  [N] to play on",
 				})
+			case g.offerReceived != noOffer:
+				g.drawSmallCenter(screen, []string{
+					"Opponent offers " + g.offerReceived.String(),
+					"Press [Y] to accept  |  [N] to decline",
+				})
+			default:
+				g.drawSmallCenter(screen, []string{"Waiting for opponent to answer your offer of " + g.offerSent.String() + "..."})
 			}
+		} else if g.undoPending {
+			ebitenutil.DrawRect(screen, 0, 0,
+				float64(WindowWidth), float64(WindowHeight),
+				color.RGBA{0, 0, 0, 180})
+
 			if g.undoRequested {
-				drawSmallCenter([]string{"Waiting for opponent to accept undo..."})
+				g.drawSmallCenter(screen, []string{"Waiting for opponent to accept undo..."})
 			} else {
-				drawSmallCenter([]string{
+				g.drawSmallCenter(screen, []string{
 					"Opponent wants to undo last move",
 					"Press [Y] to accept  |  [N] to reject",
 				})
 			}
 		}
+		if g.aiErr != nil {
+			ebitenutil.DrawRect(screen, 0, 0,
+				float64(WindowWidth), float64(WindowHeight),
+				color.RGBA{0, 0, 0, 180})
+			g.drawSmallCenter(screen, []string{
+				"The AI engine failed:",
+				g.aiErr.Error(),
+				"Undo to try again  |  ESC: Menu",
+			})
+		} else if choices := g.pos.Pending(); len(choices) > 0 && !g.undoPending {
+			g.drawChoice(screen, choices)
+		}
 	case StateGameOver:
 		g.drawBoard(screen)
 		g.drawGameOver(screen)
 	}
+	g.drawChat(screen)
+}
+
+// drawChoice asks for an opening choice in a band across the board, so the
+// stones stay in view.
+func (g *Game) drawChoice(screen *ebiten.Image, choices []rules.Choice) {
+	ebitenutil.DrawRect(screen, 0, float64(WindowHeight/2-50), float64(WindowWidth), 100, color.RGBA{0, 0, 0, 180})
+	if !g.localTurn() {
+		g.drawSmallCenter(screen, []string{"Waiting for the opponent's choice..."})
+		return
+	}
+	keys := map[rules.Choice]string{
+		rules.TakeBlack: "[B] Play Black",
+		rules.TakeWhite: "[W] Play White",
+		rules.PlaceTwo:  "[T] Place two more",
+	}
+	var options []string
+	for _, c := range choices {
+		options = append(options, keys[c])
+	}
+	g.drawSmallCenter(screen, []string{
+		g.pos.Turn().String() + ", choose:",
+		strings.Join(options, "  |  "),
+	})
+}
+
+func (g *Game) drawSmallCenter(screen *ebiten.Image, lines []string) {
+	scale := 0.5
+	lineH := int(float64(utils.MplusFont.Metrics().Height>>6) * scale)
+	totalH := lineH * len(lines)
+	startY := (WindowHeight - totalH) / 2
+
+	for i, txt := range lines {
+		b := text.BoundString(utils.MplusFont, txt)
+		img := ebiten.NewImage(b.Dx(), b.Dy())
+		img.Fill(color.Transparent)
+		text.Draw(img, txt, utils.MplusFont, 0, b.Dy(), color.White)
+
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(scale, scale)
+		op.GeoM.Translate(
+			float64(WindowWidth/2)-float64(b.Dx())*scale/2,
+			float64(startY+i*lineH),
+		)
+		screen.DrawImage(img, op)
+	}
+}
+
+// tileSize is the distance between the lines of the board being played,
+// which is always drawn BoardWidth across.
+func (g *Game) tileSize() float64 {
+	return float64(BoardWidth) / float64(g.pos.Size()-1)
+}
+
+// pointXY returns the screen position of the point row, col.
+func (g *Game) pointXY(row, col int) (x, y float64) {
+	return Margin + float64(col)*g.tileSize(), Margin + float64(row)*g.tileSize()
+}
+
+// pointAt returns the point nearest the screen position x, y, which may
+// be off the board.
+func (g *Game) pointAt(x, y int) (row, col int) {
+	col = int(math.Round((float64(x) - Margin) / g.tileSize()))
+	row = int(math.Round((float64(y) - Margin) / g.tileSize()))
+	return row, col
 }
 
 func (g *Game) drawBoard(screen *ebiten.Image) {
-	for i := 0; i < BoardSize; i++ {
-		pos := float64(Margin + i*TileSize)
+	for i := 0; i < g.pos.Size(); i++ {
+		pos, _ := g.pointXY(0, i)
 		ebitenutil.DrawLine(screen, pos, Margin, pos, WindowWidth-Margin, color.Black)
 		ebitenutil.DrawLine(screen, Margin, pos, WindowWidth-Margin, pos, color.Black)
 	}
-	for r := 0; r < BoardSize; r++ {
-		for c := 0; c < BoardSize; c++ {
-			if g.board[r][c] != Empty {
-				cx := float64(Margin + c*TileSize)
-				cy := float64(Margin + r*TileSize)
+	radius := g.tileSize() / 2 * 0.9
+	for r := 0; r < g.pos.Size(); r++ {
+		for c := 0; c < g.pos.Size(); c++ {
+			if s := g.pos.At(r, c); s != Empty {
+				cx, cy := g.pointXY(r, c)
 				col := color.Black
-				if g.board[r][c] == White {
+				if s == White {
 					col = color.White
 				}
-				ebitenutil.DrawCircle(screen, cx, cy, StoneRadius, col)
+				ebitenutil.DrawCircle(screen, cx, cy, radius, col)
+			}
+		}
+	}
+	red := color.RGBA{200, 30, 30, 255}
+	if line := g.pos.WinningLine(); len(line) > 0 {
+		first, last := line[0], line[len(line)-1]
+		x0, y0 := g.pointXY(first[0], first[1])
+		x1, y1 := g.pointXY(last[0], last[1])
+		ebitenutil.DrawLine(screen, x0, y0, x1, y1, red)
+		if g.pos.Variant().Rule == rules.Caro {
+			// Ring the free ends that let the line count.
+			dr, dc := line[1][0]-first[0], line[1][1]-first[1]
+			for _, p := range [2][2]int{{first[0] - dr, first[1] - dc}, {last[0] + dr, last[1] + dc}} {
+				if p[0] >= 0 && p[0] < g.pos.Size() && p[1] >= 0 && p[1] < g.pos.Size() && g.pos.At(p[0], p[1]) == Empty {
+					cx, cy := g.pointXY(p[0], p[1])
+					ebitenutil.DrawCircle(screen, cx, cy, radius/3, red)
+				}
 			}
 		}
 	}
+	if line, ends := g.pos.ClosedLine(); len(line) > 0 {
+		// A line that did not count: grey, with a cross on the stones
+		// closing it.
+		first, last := line[0], line[len(line)-1]
+		x0, y0 := g.pointXY(first[0], first[1])
+		x1, y1 := g.pointXY(last[0], last[1])
+		ebitenutil.DrawLine(screen, x0, y0, x1, y1, color.Gray{128})
+		for _, p := range ends {
+			cx, cy := g.pointXY(p[0], p[1])
+			d := radius / 2
+			ebitenutil.DrawLine(screen, cx-d, cy-d, cx+d, cy+d, red)
+			ebitenutil.DrawLine(screen, cx-d, cy+d, cx+d, cy-d, red)
+		}
+	}
+	for _, p := range g.forbiddenPoints() {
+		cx, cy := g.pointXY(p[0], p[1])
+		d := radius / 3
+		ebitenutil.DrawLine(screen, cx-d, cy-d, cx+d, cy+d, red)
+		ebitenutil.DrawLine(screen, cx-d, cy+d, cx+d, cy-d, red)
+	}
 }
-func (g *Game) whoAmI() Stone {
-	if g.role == "host" {
-		return Black
+
+// forbiddenPoints returns the points Black may not play when it is Black's
+// turn under a rule that forbids some. They only change with the stones,
+// so they are worked out once per position rather than every frame.
+func (g *Game) forbiddenPoints() [][2]int {
+	if !g.pos.Variant().Rule.Restricts(Black) || g.pos.ToMove() != Black || g.pos.Result() != rules.Ongoing {
+		return nil
+	}
+	board := BoardOf(g.pos)
+	if key := board.Hash(); key != g.forbiddenKey {
+		g.forbiddenKey = key
+		g.forbidden = g.forbidden[:0]
+		for _, p := range emptyPoints(board) {
+			if !board.Legal(p[0], p[1], Black) {
+				g.forbidden = append(g.forbidden, p)
+			}
+		}
 	}
-	return White
+	return g.forbidden
+}
+
+// mySide returns the player this end of a LAN game is, as the host's
+// hello set it.
+func (g *Game) mySide() rules.Side {
+	if g.conn == nil {
+		return rules.First
+	}
+	return g.conn.mySide()
+}
+
+// aiToAct reports whether the computer is to act. It plays the second
+// player, so the human places any opening stones.
+func (g *Game) aiToAct() bool {
+	return g.pos.Turn() == rules.Second
+}
+
+// localTurn reports whether a player at this screen is to act.
+func (g *Game) localTurn() bool {
+	switch g.playMode {
+	case HumanVsAI:
+		return !g.aiToAct()
+	case HumanVsLAN:
+		return g.pos.Turn() == g.mySide()
+	}
+	return true
+}
+
+// updateChoice takes a choice of colour from the keyboard.
+func (g *Game) updateChoice() {
+	keys := map[ebiten.Key]rules.Choice{ebiten.KeyB: rules.TakeBlack, ebiten.KeyW: rules.TakeWhite, ebiten.KeyT: rules.PlaceTwo}
+	for k, c := range keys {
+		if g.keyJustPressed(k) {
+			g.decide(c)
+			return
+		}
+	}
+}
+
+// decide makes an opening choice for the player to act and passes it on
+// to a LAN opponent.
+func (g *Game) decide(c rules.Choice) {
+	by := g.pos.Turn()
+	if err := g.pos.Decide(c); err != nil {
+		log.Println("choice error:", err)
+		return
+	}
+	log.Printf("%v takes %v", by, c)
+	if g.playMode == HumanVsLAN && g.conn != nil {
+		sendChoice(g.conn, c, g.pos)
+	}
+	g.lastMover = by
 }
 func (g *Game) undoMove() {
-	if g.state != StatePlaying || len(g.moveHistory) == 0 {
+	if g.state != StatePlaying || g.pos.MoveCount() == 0 {
 		return
 	}
 
 	switch g.playMode {
 	case HumanVsLAN:
-		isMyTurn := (g.role == "host" && g.currentTurn == Black) ||
-			(g.role == "client" && g.currentTurn == White)
-		if !isMyTurn && !g.undoPending && !g.undoRequested &&
-			g.lastMover == g.whoAmI() {
+		if !g.localTurn() && !g.undoPending && !g.undoRequested &&
+			g.lastMover == g.mySide() {
 			g.undoRequested = true
 			g.undoPending = true
-			_ = sendUndoRequest(g.conn)
+			_ = sendUndoRequest(g.conn, g.pos)
 		}
 		return
 
 	default:
-		steps := 1
-		if g.playMode == HumanVsAI && len(g.moveHistory) >= 2 {
-			steps = 2
-		}
-		for i := 0; i < steps; i++ {
-			if len(g.moveHistory) == 0 {
+		g.cancelAISearch()
+		g.cancelThreatHint()
+		g.aiErr = nil
+		// Against the AI, take back its reply too, back to a turn of the
+		// human's.
+		for {
+			moves := g.pos.MoveCount()
+			if g.pos.UndoTurn() != nil {
+				break
+			}
+			for i := g.pos.MoveCount(); g.engine != nil && i < moves; i++ {
+				if err := g.engine.Undo(); err != nil {
+					g.aiErr = err
+				}
+			}
+			if g.playMode != HumanVsAI || !g.aiToAct() {
 				break
 			}
-			last := g.moveHistory[len(g.moveHistory)-1]
-			g.board[last[0]][last[1]] = Empty
-			g.moveHistory = g.moveHistory[:len(g.moveHistory)-1]
-			g.moves--
-			g.currentTurn = 3 - g.currentTurn
 		}
 	}
 }
@@ -716,6 +1110,44 @@ func (g *Game) drawStatus(screen *ebiten.Image) {
 	statusTexts := []string{
 		fmt.Sprintf("Volume: %d%% (+/-)", int(g.masterVolume*100)),
 		"ESC: Menu",
+		"Rule: " + g.pos.Variant().Rule.String(),
+	}
+	if g.pos.Variant().Rule.StonesPerTurn() > 1 && g.pos.Result() == rules.Ongoing {
+		statusTexts = append(statusTexts, fmt.Sprintf("Stones to place: %d", g.pos.StonesLeft()))
+	}
+	if goal := g.pos.Variant().Rule.CaptureGoal(); goal > 0 {
+		statusTexts = append(statusTexts, fmt.Sprintf("Captured: Black %d, White %d of %d",
+			g.pos.Captured(Black), g.pos.Captured(White), goal))
+	}
+	// With the chat open, the LAN lines are in its panel instead.
+	if g.playMode == HumanVsLAN && g.conn != nil && g.chatWidth() == 0 {
+		chat := "Tab: Chat"
+		if g.chat.unread > 0 {
+			chat = fmt.Sprintf("Tab: Chat (%d new)", g.chat.unread)
+		}
+		statusTexts = append(statusTexts, fmt.Sprintf("Opponent: %s (%v)", g.conn.peer, g.mySide().Other()),
+			chat+"  R: Resign  D: Draw")
+		if g.syncing {
+			statusTexts = append(statusTexts, "Resyncing with the host...")
+		}
+	}
+	if o := g.pos.Variant().Opening; o != rules.NoOpening {
+		statusTexts = append(statusTexts, fmt.Sprintf("Opening: %v, %v to act", o, g.pos.Turn()))
+	}
+	if line, _ := g.pos.ClosedLine(); len(line) > 0 {
+		statusTexts = append(statusTexts, "Closed at both ends: no win")
+	}
+	if g.pendingAI {
+		statusTexts = append(statusTexts,
+			fmt.Sprintf("AI thinking... %.1fs", time.Since(g.aiStarted).Seconds()))
+	}
+	switch {
+	case g.hintResult != nil:
+		statusTexts = append(statusTexts, "Looking for a forced win...")
+	case g.hint.text != "" && g.hint.moves == g.pos.MoveCount():
+		statusTexts = append(statusTexts, g.hint.text)
+	case threatsApply(g.pos.Variant().Rule):
+		statusTexts = append(statusTexts, "F: Forced win?")
 	}
 
 
@@ -747,10 +1179,10 @@ func (g *Game) drawStatus(screen *ebiten.Image) {
 	text.Draw(screen, turnText, utils.MplusFont, 20, int(cy+10), color.Black)
 
 	col := color.Black
-	if g.currentTurn == White {
+	if g.pos.ToMove() == White {
 		col = color.White
 	}
-	ebitenutil.DrawCircle(screen, cx, cy, StoneRadius, col)
+	ebitenutil.DrawCircle(screen, cx, cy, 18, col)
 
 	btnX, btnY := WindowWidth-120, WindowHeight-50
 	ebitenutil.DrawRect(screen, float64(btnX), float64(btnY), 100, 30, color.RGBA{180, 180, 180, 255})
@@ -761,12 +1193,31 @@ func (g *Game) drawGameOver(screen *ebiten.Image) {
 	ebitenutil.DrawRect(screen, 0, 0, float64(WindowWidth), float64(WindowWidth), OverlayColor)
 
 	msg := "It's a Tie!"
-	if g.winner == Black {
+	if winner := g.pos.Result().Winner(); winner == Black {
 		msg = "Black Wins!"
-	} else if g.winner == White {
+	} else if winner == White {
 		msg = "White Wins!"
 	}
-	utils.DrawCenteredText(screen, msg, "Click to return to menu", utils.MplusFont, WindowWidth)
+	if ending := g.pos.Ending(); ending != "" {
+		msg += " (" + ending + ")"
+	}
+	hint := "R: Rematch  S: Save  Click: Menu"
+	if g.playMode == HumanVsLAN {
+		hint = "R: Rematch  C: Swap colours  S: Save"
+	}
+	switch {
+	case g.playMode == HumanVsLAN && g.lanState == "peerLeft":
+		hint = "Opponent left  Click: Menu"
+	case g.offerReceived == rematchOffer:
+		hint = "Rematch? Y/N"
+	case g.offerReceived == swapRematchOffer:
+		hint = "Swap colours and rematch? Y/N"
+	case g.offerSent != noOffer:
+		hint = "Waiting for the opponent..."
+	case g.savedAs != "":
+		hint = g.savedAs
+	}
+	utils.DrawCenteredText(screen, msg, hint, utils.MplusFont, WindowWidth)
 }
 
 func (g *Game) drawModeSelect(screen *ebiten.Image) {
@@ -792,15 +1243,273 @@ func (g *Game) drawModeSelect(screen *ebiten.Image) {
 }
 
 func (g *Game) undoLastMove() {
-	if len(g.moveHistory) == 0 {
+	g.cancelThreatHint()
+	if g.pos.UndoTurn() != nil {
 		return
 	}
-	last := g.moveHistory[len(g.moveHistory)-1]
-	g.board[last[0]][last[1]] = Empty
-	g.moveHistory = g.moveHistory[:len(g.moveHistory)-1]
-	g.moves--
-	g.currentTurn = 3 - g.currentTurn
-	g.lastMover = g.currentTurn
+	g.lastMover = g.pos.Turn()
+}
+
+// applyLANEvent applies a message from the peer to the game, and resyncs
+// when the peer's game turns out to differ from this one.
+func (g *Game) applyLANEvent(ev netEvent) {
+	if ev.op == "DISCONNECTED" {
+		switch {
+		case ev.from != g.conn:
+		case g.state == StateGameOver:
+			// Nothing is left to resume, only a rematch to miss.
+			g.conn.end()
+			g.lanState = "peerLeft"
+			g.offerSent, g.offerReceived = noOffer, noOffer
+		default:
+			g.startReconnect()
+		}
+		return
+	}
+	if ev.op == "CHAT" {
+		g.hear(ev.text)
+		return
+	}
+	if g.syncing && ev.op != "SYNC" {
+		return // the host's game will include it
+	}
+	switch ev.op {
+	case "MOVE":
+		if g.localTurn() {
+			g.resync("move out of turn")
+			return
+		}
+		for _, m := range ev.moves {
+			if err := g.pos.Play(m[0], m[1]); err != nil {
+				g.resync(err.Error())
+				return
+			}
+		}
+		log.Printf("[RECV] %s received: %s", g.role, formatMoves(ev.moves))
+	case "CHOICE":
+		if g.localTurn() {
+			g.resync("choice out of turn")
+			return
+		}
+		if err := g.pos.Decide(ev.choice); err != nil {
+			g.resync(err.Error())
+			return
+		}
+		g.lastMover = g.mySide().Other()
+		log.Printf("[RECV] %s received choice: %v", g.role, ev.choice)
+	case "UNDO_REQUEST":
+		if ev.check != checkOf(g.pos) {
+			sendUndoReject(g.conn)
+			g.resync("undo of another game")
+			return
+		}
+		g.undoPending = true
+		g.undoRequested = false
+		return
+	case "UNDO_ACCEPT":
+		g.undoPending, g.undoRequested = false, false
+		if ev.check != checkOf(g.pos) {
+			g.resync("undo of another game")
+			return
+		}
+		g.undoLastMove()
+		return
+	case "UNDO_REJECT":
+		g.undoPending, g.undoRequested = false, false
+		return
+	case "DESYNC":
+		g.resync("messages lost")
+		return
+	case "RESYNC":
+		if g.role == "host" {
+			log.Printf("[SYNC] host sends its game at move %d", g.pos.MoveCount())
+			sendSync(g.conn, g.pos)
+		}
+		return
+	case "SYNC":
+		g.applySync(ev.record)
+		return
+	case "RESIGN":
+		if err := g.pos.Resign(g.pos.StoneOf(g.mySide().Other())); err != nil {
+			log.Printf("[RECV] %s ignored resignation: %v", g.role, err)
+			return
+		}
+		g.state = StateGameOver
+		return
+	case "OFFER":
+		switch {
+		case ev.offer == g.offerSent:
+			// Both offered the same at once, which is as good as agreed.
+			g.offerSent = noOffer
+			g.settle(ev.offer)
+		case g.offerSent != noOffer || g.offerReceived != noOffer || !g.offerFits(ev.offer):
+			sendOffer(g.conn, msgDecline, ev.offer)
+		default:
+			g.offerReceived = ev.offer
+		}
+		return
+	case "ACCEPT":
+		if ev.offer == g.offerSent {
+			g.offerSent = noOffer
+			g.settle(ev.offer)
+		}
+		return
+	case "DECLINE":
+		if ev.offer == g.offerSent {
+			g.offerSent = noOffer
+		}
+		return
+	}
+	if reason := outOfSync(ev.check, g.pos); reason != "" {
+		g.resync(reason)
+		return
+	}
+	if g.pos.Result() != rules.Ongoing {
+		g.state = StateGameOver
+	}
+}
+
+// offerFits reports whether o can be made now: a draw during a game, a
+// rematch after it.
+func (g *Game) offerFits(o offer) bool {
+	if o == drawOffer {
+		return g.state == StatePlaying && g.pos.Result() == rules.Ongoing
+	}
+	return g.state == StateGameOver
+}
+
+// makeOffer offers o to the LAN peer. Elsewhere nobody needs asking, so
+// a rematch starts at once.
+func (g *Game) makeOffer(o offer) {
+	if g.playMode != HumanVsLAN {
+		if o == rematchOffer {
+			g.Reset(g.playMode)
+		}
+		return
+	}
+	if g.conn == nil || g.lanState == "peerLeft" || g.offerSent != noOffer || !g.offerFits(o) {
+		return
+	}
+	g.offerSent = o
+	sendOffer(g.conn, msgOffer, o)
+}
+
+// settle carries out an offer both players agreed to.
+func (g *Game) settle(o offer) {
+	switch o {
+	case drawOffer:
+		if g.pos.AgreeDraw() == nil {
+			g.state = StateGameOver
+		}
+	case rematchOffer, swapRematchOffer:
+		if o == swapRematchOffer {
+			g.conn.swapSides()
+		}
+		g.Reset(HumanVsLAN)
+	}
+}
+
+// updateOffers takes the keys for a LAN prompt: confirming a resignation or
+// answering the peer's offer. It reports whether a prompt is up, waiting
+// for an answer to our own offer included.
+func (g *Game) updateOffers() bool {
+	yes, no := g.keyJustPressed(ebiten.KeyY), g.keyJustPressed(ebiten.KeyN)
+	switch {
+	case g.confirmResign:
+		if yes && g.pos.Resign(g.pos.StoneOf(g.mySide())) == nil {
+			sendResign(g.conn)
+			g.state = StateGameOver
+		}
+		if yes || no {
+			g.confirmResign = false
+		}
+	case g.offerReceived != noOffer:
+		o := g.offerReceived
+		if yes {
+			g.offerReceived = noOffer
+			sendOffer(g.conn, msgAccept, o)
+			g.settle(o)
+		} else if no {
+			g.offerReceived = noOffer
+			sendOffer(g.conn, msgDecline, o)
+		}
+	case g.offerSent == noOffer:
+		return false
+	}
+	return true
+}
+
+// startReconnect holds the game while the connection is down: the host
+// keeps its room open for the peer, the client dials it again.
+func (g *Game) startReconnect() {
+	log.Printf("[LAN] %s lost the connection, waiting %v", g.role, reconnectGrace)
+	g.conn.Close()
+	g.lanState = "reconnecting"
+	g.reconnectBy = time.Now().Add(reconnectGrace)
+	g.undoPending, g.undoRequested = false, false
+	g.offerSent, g.offerReceived, g.confirmResign = noOffer, noOffer, false
+	g.lanOutgoing = nil
+	if g.role != "host" {
+		go g.conn.rejoin(g.reconnectBy)
+	}
+}
+
+// resume carries the game on over c, a new connection to the same peer,
+// from the host's game.
+func (g *Game) resume(c *lanConn) {
+	log.Printf("[LAN] %s resumed the game in room %s", g.role, c.room)
+	old := g.conn
+	g.conn = c
+	old.Close()
+	g.lanState = ""
+	g.undoPending, g.undoRequested = false, false
+	g.offerSent, g.offerReceived, g.confirmResign = noOffer, noOffer, false
+	g.lanOutgoing = nil
+	g.listenLAN(c, g.role)
+	if g.role == "host" {
+		sendSync(c, g.pos)
+	} else {
+		g.syncing = true
+	}
+}
+
+// resync recovers from the two ends of a LAN game disagreeing: the host's
+// game wins, so the host sends it and the client asks for it.
+func (g *Game) resync(reason string) {
+	log.Printf("[SYNC] %s out of sync: %s", g.role, reason)
+	g.undoPending, g.undoRequested = false, false
+	g.lanOutgoing = nil
+	if g.role == "host" {
+		sendSync(g.conn, g.pos)
+		return
+	}
+	g.syncing = true
+	sendResync(g.conn)
+}
+
+// applySync replaces the game with the host's, sent as a record.
+func (g *Game) applySync(record string) {
+	pos, err := syncedPosition(record, g.pos.Variant())
+	if err != nil {
+		log.Printf("[SYNC] %s cannot take the host's game: %v", g.role, err)
+		g.cleanupLAN()
+		g.lanState, g.lanErr = "failed", err
+		g.state = StateLANConnect
+		return
+	}
+	log.Printf("[SYNC] %s took the host's game at move %d", g.role, pos.MoveCount())
+	g.cancelThreatHint()
+	g.pos = pos
+	g.syncing = false
+	g.undoPending, g.undoRequested = false, false
+	g.lanOutgoing = nil
+	g.lastMover = g.mySide().Other()
+	if last, ok := pos.LastMove(); ok {
+		g.lastMover = pos.SideOf(pos.At(last[0], last[1]))
+	}
+	if pos.Result() != rules.Ongoing {
+		g.state = StateGameOver
+	}
 }
 
 func (g *Game) drawLANConnect(screen *ebiten.Image) {
@@ -834,7 +1543,7 @@ func (g *Game) drawLANConnect(screen *ebiten.Image) {
 		drawScaledText("Available Rooms (Right-click to refresh):", leftMargin, y, color.White)
 		y += int(30 * scale)
 		for i, room := range g.foundRooms {
-			ipStr := fmt.Sprintf("Room %d - %s:%d", i+1, room.IP, room.Port)
+			ipStr := fmt.Sprintf("Room %d - %s:%d (%v)", i+1, room.IP, room.Port, room.Variant)
 			var col color.Color = color.White
 			if i == g.selectedIdx {
 				col = color.RGBA{200, 255, 200, 255}
@@ -845,6 +1554,10 @@ func (g *Game) drawLANConnect(screen *ebiten.Image) {
 
 	case "failed":
 		drawScaledText("Connection failed", leftMargin, y, color.RGBA{255, 100, 100, 255})
+		if g.lanErr != nil {
+			y += int(30 * scale)
+			drawScaledText(g.lanErr.Error(), leftMargin, y, color.RGBA{255, 100, 100, 255})
+		}
 	default:
 		drawScaledText("Press [H] to HOST a game", leftMargin, y, color.White)
 		y += int(30 * scale)
@@ -856,14 +1569,16 @@ func (g *Game) drawLANConnect(screen *ebiten.Image) {
 
 func (g *Game) cleanupLAN() {
 	if g.conn != nil {
+		g.conn.end()
 		_ = g.conn.Close()
 		g.conn = nil
 	}
 	g.role = ""
 	g.lanState = ""
+	g.lanErr = nil
 	g.foundRooms = nil
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	return WindowWidth, WindowHeight
+	return WindowWidth + g.chatWidth(), WindowHeight
 }
@@ -1,68 +1,658 @@
 package src
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+
+	"wuziqi/src/rules"
 )
 
 const BroadcastPort = 55556
 
+// ProtocolVersion is the version of the LAN protocol. Peers exchange it in
+// their hellos and refuse to play a peer of another version.
+const ProtocolVersion = 1
+
+// handshakeTimeout bounds how long a new peer has to say hello.
+const handshakeTimeout = 5 * time.Second
+
+// reconnectGrace is how long a game whose connection dropped waits for the
+// players to find each other again.
+const reconnectGrace = 60 * time.Second
+
+// Envelope wraps every message on a LAN connection: the message type, a
+// sequence number counting the sender's messages from 1, and the message.
+type Envelope struct {
+	Type    string          `json:"type"`
+	Seq     uint64          `json:"seq"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Message types.
+const (
+	msgHello      = "hello"
+	msgReject     = "reject"
+	msgWelcome    = "welcome"
+	msgMove       = "move"
+	msgChoice     = "choice"
+	msgUndo       = "undo"
+	msgUndoAccept = "undoAccept"
+	msgUndoReject = "undoReject"
+	msgResync     = "resync"
+	msgSync       = "sync"
+	msgResign     = "resign"
+	msgOffer      = "offer"
+	msgAccept     = "accept"
+	msgDecline    = "decline"
+	msgChat       = "chat"
+)
+
+// HelloMsg opens a connection. The host's hello also sets the game: the
+// room, the variant and the side the joining player takes. A player
+// rejoining a game it lost the connection to proves it with the session
+// it was welcomed with. The host answers the player's hello with a welcome
+// or a reject.
+type HelloMsg struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Room      string     `json:"room,omitempty"`
+	Session   string     `json:"session,omitempty"`
+	Size      int        `json:"size,omitempty"`
+	WinLength int        `json:"winLength,omitempty"`
+	Rule      string     `json:"rule,omitempty"`
+	Opening   string     `json:"opening,omitempty"`
+	Side      rules.Side `json:"side,omitempty"`
+}
+
+// WelcomeMsg lets a player in. The player that takes the seat is given the
+// session, which only the two ends know, to prove when it rejoins.
+type WelcomeMsg struct {
+	Session string `json:"session,omitempty"`
+}
+
+// RejectMsg tells a peer why it may not play.
+type RejectMsg struct {
+	Reason string `json:"reason"`
+}
+
+// Check is what the two ends of a game should agree on: the number of
+// moves played and the hash of the position. Moves and choices carry it as
+// it is after them, undo messages as it is before the undo.
+type Check struct {
+	MoveNumber int    `json:"moveNumber"`
+	Hash       uint64 `json:"hash"`
+}
+
+func checkOf(p *rules.Position) Check {
+	return Check{MoveNumber: p.MoveCount(), Hash: p.Hash()}
+}
+
+// outOfSync returns why p is not the game the peer checked with c, or ""
+// if the two agree.
+func outOfSync(c Check, p *rules.Position) string {
+	switch {
+	case c.MoveNumber != p.MoveCount():
+		return fmt.Sprintf("peer at move %d, here %d", c.MoveNumber, p.MoveCount())
+	case c.Hash != p.Hash():
+		return fmt.Sprintf("peer's game differs at move %d", c.MoveNumber)
+	}
+	return ""
+}
+
+// syncedPosition reads the host's game from a SYNC record, which must be
+// of the variant v this end plays.
+func syncedPosition(record string, v rules.Variant) (*rules.Position, error) {
+	pos, err := rules.ParseRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if pos.Variant() != v {
+		return nil, fmt.Errorf("host plays %v, not %v", pos.Variant(), v)
+	}
+	return pos, nil
+}
+
+// NetMsg is a move: the stones of one turn, so the peer never sees half a
+// turn.
 type NetMsg struct {
-	Row int `json:"row"`
-	Col int `json:"col"`
+	Stones [][2]int `json:"stones"`
+	Check
+}
+
+// ChoiceMsg carries a player's choice during a swap opening, by name.
+type ChoiceMsg struct {
+	Choice string `json:"choice"`
+	Check
+}
+
+// UndoMsg asks for an undo or answers the request.
+type UndoMsg struct {
+	Check
+}
+
+// SyncMsg is the host's game, sent as a record for the peer to replay when
+// the two have drifted apart.
+type SyncMsg struct {
+	Record string `json:"record"`
+}
+
+// offer is something one player proposes and the other accepts or
+// declines.
+type offer int
+
+const (
+	noOffer offer = iota
+	drawOffer
+	rematchOffer
+	// swapRematchOffer is a rematch with the players' sides swapped.
+	swapRematchOffer
+	offerCount
+)
+
+var offerNames = [offerCount]string{"none", "draw", "rematch", "swap"}
+
+func (o offer) String() string {
+	switch o {
+	case drawOffer:
+		return "a draw"
+	case rematchOffer:
+		return "a rematch"
+	case swapRematchOffer:
+		return "a rematch with colours swapped"
+	}
+	return "nothing"
+}
+
+// OfferMsg makes, accepts or declines an offer, by name.
+type OfferMsg struct {
+	Offer string `json:"offer"`
+}
+
+// ChatMsg is a line of chat.
+type ChatMsg struct {
+	Text string `json:"text"`
+}
+
+// maxChatLen is the most characters a line of chat keeps.
+const maxChatLen = 200
+
+// cleanChat makes s a line fit for the chat: printable, trimmed and at most
+// maxChatLen characters.
+func cleanChat(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > maxChatLen {
+		s = strings.TrimRightFunc(string(r[:maxChatLen]), unicode.IsSpace)
+	}
+	return s
+}
+
+func parseOffer(name string) (offer, error) {
+	for i, n := range offerNames {
+		if n == name && offer(i) != noOffer {
+			return offer(i), nil
+		}
+	}
+	return noOffer, fmt.Errorf("unknown offer %q", name)
+}
+
+// netEvent is a message from the peer as recvMessage decodes it.
+type netEvent struct {
+	op     string
+	moves  [][2]int     // the stones of a MOVE, in order
+	choice rules.Choice // the choice of a CHOICE
+	check  Check        // the check of a MOVE, CHOICE or UNDO_*
+	record string       // the record of a SYNC
+	from   *lanConn     // the connection a DISCONNECTED came from
+	offer  offer        // the offer of an OFFER, ACCEPT or DECLINE
+	text   string       // the line of a CHAT
+}
+
+var (
+	// errUnknownMessage is returned for a message of a type this version
+	// does not know; the connection is still good.
+	errUnknownMessage = errors.New("unknown message type")
+	// errBadMessage is returned for a message of a known type whose
+	// payload cannot be read; the message is dropped and the connection is
+	// still good.
+	errBadMessage = errors.New("bad message")
+	// errOutOfOrder is returned for a message after a gap in the sequence,
+	// or one older than the last; the message is dropped.
+	errOutOfOrder = errors.New("message out of order")
+)
+
+// lanConn is a connection to a peer that has passed the handshake. It
+// keeps one decoder for the connection, since a decoder reads ahead and a
+// new one would lose what the last had buffered.
+type lanConn struct {
+	net.Conn
+	*lanSession
+	dec     *json.Decoder
+	mu      sync.Mutex // guards enc and seq
+	enc     *json.Encoder
+	seq     uint64
+	peerSeq uint64
+}
+
+// lanSession is the part of a LAN game that outlives a connection, so a
+// dropped game can be taken up again over a new one.
+type lanSession struct {
+	id      string        // the secret the player rejoins with
+	room    string        // names the room in its announcements
+	peer    string        // the peer's name
+	variant rules.Variant // the game the host set
+	addr    string        // the host's address, on the client
+
+	// side is the player this end is. A rematch may swap it while the host
+	// greets rejoining players with it, so it is kept behind sideMu once
+	// the game starts.
+	sideMu sync.Mutex
+	side   rules.Side
+
+	ln      net.Listener  // takes rejoining players, on the host
+	rejoins chan *lanConn // connections that resumed the session
+	done    chan struct{} // closed when the game is over
+	once    sync.Once
+}
+
+func newLANConn(conn net.Conn, s *lanSession) *lanConn {
+	return &lanConn{Conn: conn, lanSession: s, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn)}
+}
+
+func newSession() *lanSession {
+	return &lanSession{rejoins: make(chan *lanConn, 1), done: make(chan struct{})}
+}
+
+// end stops the session taking connections back.
+func (s *lanSession) end() {
+	s.once.Do(func() {
+		close(s.done)
+		if s.ln != nil {
+			s.ln.Close()
+		}
+	})
+}
+
+// mySide returns the player this end is.
+func (s *lanSession) mySide() rules.Side {
+	s.sideMu.Lock()
+	defer s.sideMu.Unlock()
+	return s.side
+}
+
+// swapSides makes this end the other player, for a rematch with colours
+// swapped.
+func (s *lanSession) swapSides() {
+	s.sideMu.Lock()
+	defer s.sideMu.Unlock()
+	s.side = s.side.Other()
+}
+
+// ended reports whether end was called.
+func (s *lanSession) ended() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// newSessionID returns a random ID, for a session or a room.
+func newSessionID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// send wraps payload, which may be nil, in the next envelope.
+func (c *lanConn) send(typ string, payload any) error {
+	env := Envelope{Type: typ}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		env.Payload = raw
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	env.Seq = c.seq
+	return c.enc.Encode(env)
+}
+
+// recv reads the next envelope and checks that none went missing. After a
+// gap the sequence carries on from the message read.
+func (c *lanConn) recv() (Envelope, error) {
+	var env Envelope
+	if err := c.dec.Decode(&env); err != nil {
+		return env, err
+	}
+	if env.Seq != c.peerSeq+1 {
+		err := fmt.Errorf("%w: %d after %d", errOutOfOrder, env.Seq, c.peerSeq)
+		c.peerSeq = max(c.peerSeq, env.Seq)
+		return env, err
+	}
+	c.peerSeq = env.Seq
+	return env, nil
+}
+
+// reject tells the peer why it may not play and returns that as an error.
+func (c *lanConn) reject(format string, args ...any) error {
+	reason := fmt.Sprintf(format, args...)
+	c.send(msgReject, RejectMsg{Reason: reason})
+	return errors.New(reason)
+}
+
+// recvHello reads the peer's hello and checks its version.
+func (c *lanConn) recvHello() (HelloMsg, error) {
+	var hello HelloMsg
+	env, err := c.recv()
+	if err != nil {
+		return hello, fmt.Errorf("waiting for hello: %w", err)
+	}
+	switch env.Type {
+	case msgHello:
+	case msgReject:
+		var rej RejectMsg
+		json.Unmarshal(env.Payload, &rej)
+		return hello, fmt.Errorf("peer refused: %s", rej.Reason)
+	default:
+		return hello, c.reject("expected hello, got %q", env.Type)
+	}
+	if err := json.Unmarshal(env.Payload, &hello); err != nil {
+		return hello, c.reject("bad hello: %v", err)
+	}
+	if hello.Version != ProtocolVersion {
+		return hello, c.reject("protocol version %d does not match %d", hello.Version, ProtocolVersion)
+	}
+	return hello, nil
+}
+
+// hostHandshake greets a joining player with the session's game and the
+// side it plays, and waits for its hello. The first player to complete it
+// takes the seat and is given the session ID. After that, only a player
+// that proves the ID is let in, so the ID is never sent again, and the
+// session is left as it is: the game may be reading it meanwhile.
+func (c *lanConn) hostHandshake() error {
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+	v := c.variant
+	err := c.send(msgHello, HelloMsg{
+		Version: ProtocolVersion, Name: clientName(), Room: c.room,
+		Size: v.Size, WinLength: v.WinLength, Rule: v.Rule.String(), Opening: v.Opening.String(),
+		Side: c.mySide().Other(),
+	})
+	if err != nil {
+		return err
+	}
+	hello, err := c.recvHello()
+	if err != nil {
+		return err
+	}
+	if c.peer != "" {
+		if subtle.ConstantTimeCompare([]byte(hello.Session), []byte(c.id)) != 1 {
+			return c.reject("a game is already being played here")
+		}
+		return c.send(msgWelcome, nil)
+	}
+	c.peer = hello.Name
+	return c.send(msgWelcome, WelcomeMsg{Session: c.id})
+}
+
+// joinHandshake reads the host's hello, takes the game it sets and answers.
+// Rejoining, it checks that the host still runs the same room before it
+// proves the session.
+func (c *lanConn) joinHandshake() error {
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+	hello, err := c.recvHello()
+	if err != nil {
+		return err
+	}
+	if c.id != "" {
+		if hello.Room != c.room {
+			return c.reject("this is not room %s", c.room)
+		}
+		_, err := c.answerHello(c.id)
+		return err
+	}
+	v := rules.Variant{Size: hello.Size, WinLength: hello.WinLength}
+	if v.Rule, err = rules.ParseRule(hello.Rule); err != nil {
+		return c.reject("cannot play rule %q", hello.Rule)
+	}
+	if v.Opening, err = rules.ParseOpening(hello.Opening); err != nil {
+		return c.reject("cannot play opening %q", hello.Opening)
+	}
+	if err := v.Validate(); err != nil {
+		return c.reject("cannot play %v: %v", v, err)
+	}
+	if hello.Side != rules.First && hello.Side != rules.Second {
+		return c.reject("no such side %d", int(hello.Side))
+	}
+	welcome, err := c.answerHello("")
+	if err != nil {
+		return err
+	}
+	if welcome.Session == "" {
+		return errors.New("host gave no session")
+	}
+	c.id, c.room, c.peer, c.variant, c.side = welcome.Session, hello.Room, hello.Name, v, hello.Side
+	return nil
+}
+
+// answerHello sends the joining player's hello, with the session it
+// rejoins if any, and waits for the host to welcome it.
+func (c *lanConn) answerHello(session string) (WelcomeMsg, error) {
+	var welcome WelcomeMsg
+	if err := c.send(msgHello, HelloMsg{Version: ProtocolVersion, Name: clientName(), Session: session}); err != nil {
+		return welcome, err
+	}
+	env, err := c.recv()
+	switch {
+	case err != nil:
+		return welcome, fmt.Errorf("waiting for welcome: %w", err)
+	case env.Type == msgReject:
+		var rej RejectMsg
+		json.Unmarshal(env.Payload, &rej)
+		return welcome, fmt.Errorf("peer refused: %s", rej.Reason)
+	case env.Type != msgWelcome:
+		return welcome, fmt.Errorf("expected welcome, got %q", env.Type)
+	}
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &welcome); err != nil {
+			return welcome, fmt.Errorf("bad welcome: %w", err)
+		}
+	}
+	return welcome, nil
+}
+
+// clientName is the name this end gives its peer.
+func clientName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "wuziqi"
 }
 
 type RoomInfo struct {
-	IP   string
-	Port int
+	IP      string
+	Port    int
+	Variant rules.Variant
+	Room    string
+}
+
+// legacyVariant is the game hosts played before rooms announced one.
+var legacyVariant = rules.Variant{Size: 8, WinLength: 5}
+
+func sendUndoRequest(conn *lanConn, p *rules.Position) error {
+	return conn.send(msgUndo, UndoMsg{checkOf(p)})
+}
+
+func sendUndoAccept(conn *lanConn, p *rules.Position) error {
+	return conn.send(msgUndoAccept, UndoMsg{checkOf(p)})
+}
+
+func sendUndoReject(conn *lanConn) error {
+	return conn.send(msgUndoReject, nil)
 }
 
-type UndoRequestMsg struct {
-	Undo bool `json:"undo"`
+// sendChoice sends the choice c, which p has just taken.
+func sendChoice(conn *lanConn, c rules.Choice, p *rules.Position) error {
+	return conn.send(msgChoice, ChoiceMsg{Choice: c.String(), Check: checkOf(p)})
 }
 
-type UndoAcceptMsg struct {
-	UndoAccept bool `json:"undoAccept"`
+// sendResync asks the host for its game.
+func sendResync(conn *lanConn) error {
+	return conn.send(msgResync, nil)
 }
 
-type UndoRejectMsg struct {
-	UndoReject bool `json:"undoReject"`
+// sendResign resigns the game for this end.
+func sendResign(conn *lanConn) error {
+	return conn.send(msgResign, nil)
 }
 
-func sendUndoRequest(conn net.Conn) error {
-	return json.NewEncoder(conn).Encode(UndoRequestMsg{Undo: true})
+// sendOffer makes, accepts or declines o, as typ says.
+func sendOffer(conn *lanConn, typ string, o offer) error {
+	return conn.send(typ, OfferMsg{Offer: offerNames[o]})
 }
 
-func sendUndoAccept(conn net.Conn) error {
-	return json.NewEncoder(conn).Encode(UndoAcceptMsg{UndoAccept: true})
+// sendChat sends a line of chat, cleaned as the peer will show it.
+func sendChat(conn *lanConn, text string) error {
+	return conn.send(msgChat, ChatMsg{Text: cleanChat(text)})
 }
 
-func sendUndoReject(conn net.Conn) error {
-	return json.NewEncoder(conn).Encode(UndoRejectMsg{UndoReject: true})
+// sendSync sends the host's game, p.
+func sendSync(conn *lanConn, p *rules.Position) error {
+	return conn.send(msgSync, SyncMsg{Record: p.Record()})
 }
 
-func HostGame() (net.Conn, error) {
+// HostGame announces a room for a game of v and waits for a player that
+// completes the handshake. Players that do not are turned away. Until the
+// session ends, the room stays open for the player to rejoin.
+func HostGame(v rules.Variant) (*lanConn, error) {
 	ln, err := net.Listen("tcp", ":0")
 	if err != nil {
 		return nil, err
 	}
+	s := newSession()
+	s.id, s.room, s.variant, s.side, s.ln = newSessionID(), newSessionID(), v, rules.First, ln
 	port := ln.Addr().(*net.TCPAddr).Port
-	go broadcastRoom(port)
-	conn, err := ln.Accept()
-	return conn, err
+	go broadcastRoom(port, s)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.end()
+			return nil, err
+		}
+		c := newLANConn(conn, s)
+		if err := c.hostHandshake(); err != nil {
+			log.Printf("[LAN] turned away %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		go s.serveRejoins()
+		return c, nil
+	}
+}
 
+// serveRejoins takes the player back whenever it connects again, which it
+// may do before the host notices the old connection is gone. Each
+// connection shakes hands on its own, so one that never says hello does
+// not keep the player out.
+func (s *lanSession) serveRejoins() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return // ended
+		}
+		go func() {
+			c := newLANConn(conn, s)
+			if err := c.hostHandshake(); err != nil {
+				log.Printf("[LAN] turned away %v: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			s.deliver(c)
+		}()
+	}
+}
+
+// deliver hands c to the game, in place of any resumed connection it has
+// not taken yet.
+func (s *lanSession) deliver(c *lanConn) {
+	for {
+		select {
+		case s.rejoins <- c:
+			return
+		case old := <-s.rejoins:
+			old.Close()
+		}
+	}
+}
+
+// rejoin dials the host of the session again until it answers, the session
+// ends or deadline passes: first at its address, then wherever discovery
+// finds the room.
+func (s *lanSession) rejoin(deadline time.Time) {
+	for time.Now().Before(deadline) && !s.ended() {
+		if s.tryRejoin(s.addr) {
+			return
+		}
+		if rooms, err := DiscoverRooms(time.Second); err == nil {
+			for _, r := range rooms {
+				if r.Room == s.room && s.tryRejoin(net.JoinHostPort(r.IP, strconv.Itoa(r.Port))) {
+					return
+				}
+			}
+		}
+		time.Sleep(time.Second)
+	}
 }
 
-func broadcastRoom(port int) {
+// tryRejoin dials the host at addr and hands the game the connection if it
+// resumes the session.
+func (s *lanSession) tryRejoin(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return false
+	}
+	c := newLANConn(conn, s)
+	if err := c.joinHandshake(); err != nil {
+		log.Printf("[LAN] rejoin %s: %v", addr, err)
+		conn.Close()
+		return false
+	}
+	s.addr = addr
+	s.deliver(c)
+	return true
+}
+
+func broadcastRoom(port int, s *lanSession) {
 	bcastAddr := &net.UDPAddr{IP: net.IPv4bcast, Port: BroadcastPort}
 	conn, _ := net.DialUDP("udp", nil, bcastAddr)
 	defer conn.Close()
-	msg := fmt.Sprintf("%s:%d", localIP(), port)
-	for {
+	v := s.variant
+	msg := fmt.Sprintf("%s:%d:%d:%d:%v:%v:%s", localIP(), port, v.Size, v.WinLength, v.Rule, v.Opening, s.room)
+	for !s.ended() {
 		conn.Write([]byte(msg))
 		time.Sleep(1 * time.Second)
 	}
@@ -77,7 +667,7 @@ func DiscoverRooms(timeout time.Duration) ([]RoomInfo, error) {
 	sock.SetDeadline(time.Now().Add(timeout))
 
 	rooms := map[string]RoomInfo{}
-	buf := make([]byte, 64)
+	buf := make([]byte, 128)
 	for {
 		n, addr, err := sock.ReadFromUDP(buf)
 		if err != nil {
@@ -87,11 +677,33 @@ func DiscoverRooms(timeout time.Duration) ([]RoomInfo, error) {
 			return nil, err
 		}
 		parts := strings.Split(string(buf[:n]), ":")
-		if len(parts) != 2 {
+		if len(parts) != 2 && (len(parts) < 4 || len(parts) > 7) {
 			continue
 		}
 		port, _ := strconv.Atoi(parts[1])
-		rooms[addr.IP.String()] = RoomInfo{IP: addr.IP.String(), Port: port}
+		v := legacyVariant
+		if len(parts) >= 4 {
+			v.Size, _ = strconv.Atoi(parts[2])
+			v.WinLength, _ = strconv.Atoi(parts[3])
+			if len(parts) >= 5 {
+				if v.Rule, err = rules.ParseRule(parts[4]); err != nil {
+					continue
+				}
+			}
+			if len(parts) >= 6 {
+				if v.Opening, err = rules.ParseOpening(parts[5]); err != nil {
+					continue
+				}
+			}
+			if v.Validate() != nil {
+				continue
+			}
+		}
+		room := RoomInfo{IP: addr.IP.String(), Port: port, Variant: v}
+		if len(parts) == 7 {
+			room.Room = parts[6]
+		}
+		rooms[addr.IP.String()] = room
 	}
 
 	out := make([]RoomInfo, 0, len(rooms))
@@ -100,10 +712,25 @@ func DiscoverRooms(timeout time.Duration) ([]RoomInfo, error) {
 	}
 	return out, nil
 }
-func JoinRoom(room RoomInfo) (net.Conn, error) {
+
+// JoinRoom connects to a room and completes the handshake. The game is the
+// one the host's hello sets, not the one its room announced.
+func JoinRoom(room RoomInfo) (*lanConn, error) {
 	addr := net.JoinHostPort(room.IP, strconv.Itoa(room.Port))
-	return net.Dial("tcp", addr)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := newSession()
+	s.addr = addr
+	c := newLANConn(conn, s)
+	if err := c.joinHandshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
 }
+
 func localIP() string {
 	addrs, _ := net.InterfaceAddrs()
 	for _, addr := range addrs {
@@ -116,35 +743,83 @@ func localIP() string {
 	return "127.0.0.1"
 }
 
-func sendMove(conn net.Conn, row, col int) error {
-	fmt.Printf("[SEND] Row=%d Col=%d\n", row, col)
-	return json.NewEncoder(conn).Encode(NetMsg{Row: row, Col: col})
+// sendMoves sends the stones of one turn, which p has just played.
+func sendMoves(conn *lanConn, moves [][2]int, p *rules.Position) error {
+	return conn.send(msgMove, NetMsg{Stones: moves, Check: checkOf(p)})
 }
 
-func recvMessage(conn net.Conn) (int, int, string, error) {
-	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-	var raw json.RawMessage
-	if err := json.NewDecoder(conn).Decode(&raw); err != nil {
-		return 0, 0, "PEER_LEFT", err
+// recvMessage waits for the next message from the peer. A message of an
+// unknown type is reported as errUnknownMessage, and one whose payload
+// cannot be read as errBadMessage; either is skipped. A message out
+// of sequence is dropped and reported as a DESYNC, since the game may have
+// missed something.
+func recvMessage(conn *lanConn) (netEvent, error) {
+	env, err := conn.recv()
+	if errors.Is(err, errOutOfOrder) {
+		log.Printf("[RECV] dropped %s: %v", env.Type, err)
+		return netEvent{op: "DESYNC"}, nil
 	}
-
-	var undoReq UndoRequestMsg
-	if json.Unmarshal(raw, &undoReq) == nil && undoReq.Undo {
-		return 0, 0, "UNDO_REQUEST", nil
-	}
-	var undoAcc UndoAcceptMsg
-	if json.Unmarshal(raw, &undoAcc) == nil && undoAcc.UndoAccept {
-		return 0, 0, "UNDO_ACCEPT", nil
-	}
-	var undoRej UndoRejectMsg
-	if json.Unmarshal(raw, &undoRej) == nil && undoRej.UndoReject {
-		return 0, 0, "UNDO_REJECT", nil
+	if err != nil {
+		return netEvent{}, err
 	}
-
-	var move NetMsg
-	if json.Unmarshal(raw, &move) == nil {
-		return move.Row, move.Col, "MOVE", nil
+	switch env.Type {
+	case msgUndo, msgUndoAccept:
+		var msg UndoMsg
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return netEvent{}, fmt.Errorf("%w: %s: %v", errBadMessage, env.Type, err)
+		}
+		op := "UNDO_REQUEST"
+		if env.Type == msgUndoAccept {
+			op = "UNDO_ACCEPT"
+		}
+		return netEvent{op: op, check: msg.Check}, nil
+	case msgUndoReject:
+		return netEvent{op: "UNDO_REJECT"}, nil
+	case msgChoice:
+		var msg ChoiceMsg
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return netEvent{}, fmt.Errorf("%w: choice: %v", errBadMessage, err)
+		}
+		c, err := rules.ParseChoice(msg.Choice)
+		if err != nil {
+			return netEvent{}, fmt.Errorf("%w: %v", errBadMessage, err)
+		}
+		return netEvent{op: "CHOICE", choice: c, check: msg.Check}, nil
+	case msgMove:
+		var msg NetMsg
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return netEvent{}, fmt.Errorf("%w: move: %v", errBadMessage, err)
+		}
+		if len(msg.Stones) == 0 {
+			return netEvent{}, fmt.Errorf("%w: move without stones", errBadMessage)
+		}
+		return netEvent{op: "MOVE", moves: msg.Stones, check: msg.Check}, nil
+	case msgResync:
+		return netEvent{op: "RESYNC"}, nil
+	case msgSync:
+		var msg SyncMsg
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return netEvent{}, fmt.Errorf("%w: sync: %v", errBadMessage, err)
+		}
+		return netEvent{op: "SYNC", record: msg.Record}, nil
+	case msgResign:
+		return netEvent{op: "RESIGN"}, nil
+	case msgOffer, msgAccept, msgDecline:
+		var msg OfferMsg
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return netEvent{}, fmt.Errorf("%w: %s: %v", errBadMessage, env.Type, err)
+		}
+		o, err := parseOffer(msg.Offer)
+		if err != nil {
+			return netEvent{}, fmt.Errorf("%w: %v", errUnknownMessage, err)
+		}
+		return netEvent{op: strings.ToUpper(env.Type), offer: o}, nil
+	case msgChat:
+		var msg ChatMsg
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return netEvent{}, fmt.Errorf("%w: chat: %v", errBadMessage, err)
+		}
+		return netEvent{op: "CHAT", text: cleanChat(msg.Text)}, nil
 	}
-
-	return 0, 0, "PEER_LEFT", nil
+	return netEvent{}, fmt.Errorf("%w %q", errUnknownMessage, env.Type)
 }
@@ -0,0 +1,365 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"wuziqi/src/rules"
+)
+
+// pipe returns the two ends of a LAN game joined by net.Pipe.
+func pipe(t *testing.T) (host, client *lanConn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return newLANConn(a, newSession()), newLANConn(b, newSession())
+}
+
+// async runs send on its own goroutine, since a pipe's writes wait for the
+// reader, and returns its error when the message has been read.
+func async(send func() error) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- send() }()
+	return done
+}
+
+// sendSeq writes an envelope with the sequence number seq, as if those
+// before it had been lost or overtaken.
+func sendSeq(c *lanConn, seq uint64, typ string) <-chan error {
+	return async(func() error {
+		return c.enc.Encode(Envelope{Type: typ, Seq: seq})
+	})
+}
+
+func TestRecvOutOfOrder(t *testing.T) {
+	host, client := pipe(t)
+	for _, tc := range []struct {
+		name  string
+		seq   uint64
+		order bool
+	}{
+		{"first", 1, true},
+		{"next", 2, true},
+		{"after a dropped message", 4, false},
+		{"carrying on after the gap", 5, true},
+		{"overtaken", 3, false},
+		{"repeated", 5, false},
+		{"next again", 6, true},
+	} {
+		sent := sendSeq(host, tc.seq, msgResign)
+		_, err := client.recv()
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+		if got := !errors.Is(err, errOutOfOrder); got != tc.order {
+			t.Errorf("%s: seq %d read with %v", tc.name, tc.seq, err)
+		}
+	}
+
+	sent := sendSeq(host, 8, msgResign)
+	ev, err := recvMessage(client)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if err != nil || ev.op != "DESYNC" {
+		t.Errorf("a dropped message gave %q, %v; want a DESYNC", ev.op, err)
+	}
+}
+
+// recvEvent reads the next event on c while send writes it from the other
+// end.
+func recvEvent(t *testing.T, c *lanConn, send func() error) netEvent {
+	t.Helper()
+	sent := async(send)
+	ev, err := recvMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestResyncAfterMismatch(t *testing.T) {
+	host, client := pipe(t)
+	hostPos, clientPos := rules.NewPosition(rules.Standard), rules.NewPosition(rules.Standard)
+	for _, m := range [][2]int{{7, 7}, {7, 8}} {
+		hostPos.Play(m[0], m[1])
+	}
+	// The client took White's stone on the wrong point.
+	for _, m := range [][2]int{{7, 7}, {6, 8}} {
+		clientPos.Play(m[0], m[1])
+	}
+
+	move := [][2]int{{8, 8}}
+	if err := hostPos.Play(8, 8); err != nil {
+		t.Fatal(err)
+	}
+	ev := recvEvent(t, client, func() error { return sendMoves(host, move, hostPos) })
+	if ev.op != "MOVE" {
+		t.Fatalf("got %q, want a MOVE", ev.op)
+	}
+	for _, m := range ev.moves {
+		if err := clientPos.Play(m[0], m[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if outOfSync(ev.check, clientPos) == "" {
+		t.Fatal("the client did not see the games differ")
+	}
+
+	if ev := recvEvent(t, host, func() error { return sendResync(client) }); ev.op != "RESYNC" {
+		t.Fatalf("got %q, want a RESYNC", ev.op)
+	}
+	ev = recvEvent(t, client, func() error { return sendSync(host, hostPos) })
+	if ev.op != "SYNC" {
+		t.Fatalf("got %q, want a SYNC", ev.op)
+	}
+	synced, err := syncedPosition(ev.record, clientPos.Variant())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.Hash() != hostPos.Hash() || outOfSync(checkOf(hostPos), synced) != "" {
+		t.Errorf("after the sync the client has\n%s\nthe host\n%s", synced.Record(), hostPos.Record())
+	}
+
+	// The game carries on in step.
+	if err := synced.Play(9, 9); err != nil {
+		t.Fatal(err)
+	}
+	ev = recvEvent(t, host, func() error { return sendMoves(client, [][2]int{{9, 9}}, synced) })
+	hostPos.Play(ev.moves[0][0], ev.moves[0][1])
+	if reason := outOfSync(ev.check, hostPos); reason != "" || hostPos.Hash() != synced.Hash() {
+		t.Errorf("out of sync after the resync: %s", reason)
+	}
+}
+
+func TestSyncOfAnotherVariant(t *testing.T) {
+	p := rules.NewPosition(rules.Variant{Size: 19, WinLength: 6, Rule: rules.Connect6})
+	if _, err := syncedPosition(p.Record(), rules.Standard); err == nil {
+		t.Error("a game of another variant was taken")
+	}
+	if _, err := syncedPosition("not a record", rules.Standard); err == nil {
+		t.Error("a broken record was taken")
+	}
+}
+
+func TestOffers(t *testing.T) {
+	host, client := pipe(t)
+	host.side, client.side = rules.First, rules.Second
+	for _, tc := range []struct {
+		offer  offer
+		answer string
+	}{
+		{drawOffer, msgAccept},
+		{drawOffer, msgDecline},
+		{rematchOffer, msgDecline},
+		{rematchOffer, msgAccept},
+		{swapRematchOffer, msgAccept},
+	} {
+		ev := recvEvent(t, client, func() error { return sendOffer(host, msgOffer, tc.offer) })
+		if ev.op != "OFFER" || ev.offer != tc.offer {
+			t.Fatalf("offering %v read as %q of %v", tc.offer, ev.op, ev.offer)
+		}
+		ev = recvEvent(t, host, func() error { return sendOffer(client, tc.answer, ev.offer) })
+		if want := strings.ToUpper(tc.answer); ev.op != want || ev.offer != tc.offer {
+			t.Fatalf("answering %v read as %q of %v, want %q", tc.offer, ev.op, ev.offer, want)
+		}
+		if ev.op == "ACCEPT" && ev.offer == swapRematchOffer {
+			host.swapSides()
+			client.swapSides()
+		}
+	}
+	if host.mySide() != rules.Second || client.mySide() != rules.First {
+		t.Errorf("after the swap the host is %v and the client %v", host.mySide(), client.mySide())
+	}
+
+	for _, name := range []string{offerNames[noOffer], "truce", ""} {
+		if o, err := parseOffer(name); err == nil {
+			t.Errorf("parseOffer(%q) = %v", name, o)
+		}
+		sent := async(func() error { return host.send(msgOffer, OfferMsg{Offer: name}) })
+		_, err := recvMessage(client)
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(err, errUnknownMessage) {
+			t.Errorf("an offer of %q read with %v", name, err)
+		}
+	}
+}
+
+func TestCleanChat(t *testing.T) {
+	long := strings.Repeat("五子棋", 100) // 300 runes of three bytes each
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "good game", "good game"},
+		{"empty", "", ""},
+		{"trimmed", " \t hi \n", "hi"},
+		{"only spaces", "  \r\n ", ""},
+		{"control characters", "a\x00b\x1bc\td\u007fe\u0085f", "a b c d e f"},
+		{"line breaks", "one\ntwo\r\nthree", "one two  three"},
+		{"multi-byte", "  你好 ☺ ", "你好 ☺"},
+		{"at the limit", strings.Repeat("é", maxChatLen), strings.Repeat("é", maxChatLen)},
+		{"multi-byte truncated", long, long[:maxChatLen*3]},
+		{"truncated at a space", strings.Repeat("x", maxChatLen-1) + "   more", strings.Repeat("x", maxChatLen-1)},
+		{"trimmed before truncating", "\n\n" + strings.Repeat("ü", maxChatLen+5), strings.Repeat("ü", maxChatLen)},
+	} {
+		got := cleanChat(tc.in)
+		if got != tc.want {
+			t.Errorf("%s: cleanChat(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) > maxChatLen {
+			t.Errorf("%s: cleanChat gave %d runes, valid %v", tc.name, utf8.RuneCountInString(got), utf8.ValidString(got))
+		}
+	}
+}
+
+// handshake runs a handshake between the host and a player whose
+// session is s, and returns the host's error and the player's.
+func handshake(t *testing.T, host *lanSession, s *lanSession) (hostErr, joinErr error) {
+	t.Helper()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	done := async(func() error { return newLANConn(a, host).hostHandshake() })
+	joinErr = newLANConn(b, s).joinHandshake()
+	if joinErr != nil {
+		b.Close() // the host may still be waiting for the player
+	}
+	return <-done, joinErr
+}
+
+func TestSessionOnlyForTheSeat(t *testing.T) {
+	host := newSession()
+	host.id, host.room, host.variant, host.side = newSessionID(), newSessionID(), rules.Standard, rules.First
+
+	player := newSession()
+	if hostErr, err := handshake(t, host, player); hostErr != nil || err != nil {
+		t.Fatalf("taking the seat: host %v, player %v", hostErr, err)
+	}
+	if player.id != host.id || player.room != host.room {
+		t.Fatalf("player holds session %q of room %q, want %q of %q", player.id, player.room, host.id, host.room)
+	}
+
+	// Once the seat is taken, the host's hello no longer gives the session
+	// to whoever connects.
+	a, b := net.Pipe()
+	go newLANConn(a, host).hostHandshake()
+	var env Envelope
+	if err := json.NewDecoder(b).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	a.Close()
+	if strings.Contains(string(env.Payload), host.id) {
+		t.Errorf("the host's hello gave the session away: %s", env.Payload)
+	}
+
+	for _, tc := range []struct {
+		name string
+		id   string
+		room string
+		ok   bool
+	}{
+		{"a new player", "", "", false},
+		{"a player guessing", newSessionID(), host.room, false},
+		{"the player rejoining", host.id, host.room, true},
+	} {
+		s := newSession()
+		s.id, s.room = tc.id, tc.room
+		hostErr, err := handshake(t, host, s)
+		if ok := hostErr == nil && err == nil; ok != tc.ok {
+			t.Errorf("%s: host %v, player %v", tc.name, hostErr, err)
+		}
+	}
+
+	// A player rejoining another room keeps its session to itself.
+	s := newSession()
+	s.id, s.room = host.id, newSessionID()
+	if _, err := handshake(t, host, s); err == nil {
+		t.Error("the player rejoined another room")
+	}
+}
+
+func TestRejoinPastASilentPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	host := newSession()
+	host.id, host.room, host.peer, host.variant, host.side, host.ln = newSessionID(), newSessionID(), "player", rules.Standard, rules.First, ln
+	defer host.end()
+	go host.serveRejoins()
+
+	// A stranger connects and never says hello.
+	stranger, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+
+	player := newSession()
+	player.id, player.room = host.id, host.room
+	start := time.Now()
+	if !player.tryRejoin(ln.Addr().String()) {
+		t.Fatal("the player could not rejoin")
+	}
+	if waited := time.Since(start); waited >= handshakeTimeout {
+		t.Errorf("the player waited %v behind the stranger", waited)
+	}
+	select {
+	case c := <-host.rejoins:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Error("the host did not take the player back")
+	}
+	(<-player.rejoins).Close()
+	if host.peer != "player" {
+		t.Errorf("rejoining renamed the peer %q", host.peer)
+	}
+}
+
+func TestRecvBadPayload(t *testing.T) {
+	host, client := pipe(t)
+	for _, tc := range []struct {
+		typ     string
+		payload any
+	}{
+		{msgMove, json.RawMessage(`{"stones":[]}`)},
+		{msgMove, json.RawMessage(`{"stones":"7,7"}`)},
+		{msgChoice, ChoiceMsg{Choice: "grey"}},
+		{msgUndo, json.RawMessage(`[]`)},
+		{msgChat, json.RawMessage(`{"text":7}`)},
+		{msgSync, json.RawMessage(`"record"`)},
+	} {
+		sent := async(func() error { return host.send(tc.typ, tc.payload) })
+		_, err := recvMessage(client)
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(err, errBadMessage) {
+			t.Errorf("%s %v: read with %v, want %v", tc.typ, tc.payload, err, errBadMessage)
+		}
+	}
+
+	// The connection is still good.
+	p := rules.NewPosition(rules.Standard)
+	p.Play(7, 7)
+	ev := recvEvent(t, client, func() error { return sendMoves(host, [][2]int{{7, 7}}, p) })
+	if ev.op != "MOVE" || outOfSync(ev.check, p) != "" {
+		t.Errorf("after the bad messages read %q with %v", ev.op, ev.check)
+	}
+}
@@ -0,0 +1,89 @@
+package src
+
+import (
+	"fmt"
+
+	"wuziqi/src/rules"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+var boardSizes = []int{8, 13, 15, 19}
+
+var winLengths = []int{3, 4, 5, 6}
+
+// openNewGame shows the new game screen, where the board is chosen before
+// a game in mode starts.
+func (g *Game) openNewGame(mode PlayMode) {
+	g.newGameMode = mode
+	g.state = StateNewGame
+}
+
+func (g *Game) newGameLines() []string {
+	lines := []string{
+		fmt.Sprintf("Board size: %dx%d", g.variant.Size, g.variant.Size),
+		fmt.Sprintf("Stones in a row: %d", g.variant.WinLength),
+		"Rule: " + g.variant.Rule.String(),
+		"Opening: " + g.variant.Opening.String(),
+	}
+	if g.newGameMode == HumanVsLAN {
+		lines = append(lines, "Joining players take the host's board")
+	}
+	return lines
+}
+
+func (g *Game) updateNewGame() {
+	if step := selectOption(&g.newGameIdx, 4); step != 0 {
+		v := g.variant
+		switch g.newGameIdx {
+		case 0:
+			v.Size = stepThrough(boardSizes, v.Size, step)
+			v.WinLength = min(v.WinLength, v.Size)
+		case 1:
+			v.WinLength = stepThrough(winLengths, v.WinLength, step)
+		case 2:
+			rs := rules.Rules()
+			v.Rule = rs[(int(v.Rule)+step+len(rs))%len(rs)]
+			switch v.Rule {
+			case rules.Renju, rules.Caro:
+				v.WinLength = 5
+			case rules.Connect6:
+				v.WinLength = 6
+				v.Opening = rules.NoOpening
+			}
+		case 3:
+			// Skip openings the board cannot take.
+			ops := rules.Openings()
+			for range ops {
+				v.Opening = ops[(int(v.Opening)+step+len(ops))%len(ops)]
+				if v.Validate() == nil {
+					break
+				}
+			}
+		}
+		if v.Validate() == nil {
+			g.variant = v
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.state = StateModeSelect
+		return
+	}
+	if !inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		return
+	}
+	switch g.newGameMode {
+	case HumanVsHuman:
+		g.Reset(HumanVsHuman)
+	case HumanVsAI:
+		g.state = StateDifficultySelect
+	case HumanVsLAN:
+		g.state = StateLANConnect
+	}
+}
+
+func (g *Game) drawNewGame(screen *ebiten.Image) {
+	drawOptions(screen, "New Game", g.newGameLines(), g.newGameIdx, "Enter: Start  ESC: Back")
+}
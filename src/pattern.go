@@ -0,0 +1,156 @@
+package src
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Scores of the line a stone would make in one direction, by the length of
+// its run and how many ends are left open. They are named for five in a
+// row; shapeScore shifts other line lengths onto them.
+const (
+	scoreFive      = 1000000
+	scoreOpenFour  = 100000
+	scoreFour      = 12000
+	scoreOpenThree = 10000
+	scoreThree     = 1000
+	scoreOpenTwo   = 1000
+	scoreTwo       = 100
+	scoreOne       = 10
+)
+
+var lineDirs = [4][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+
+// lineShape measures the run p would have through row, col along dr, dc if
+// it played there, and how many of the run's ends are empty.
+func lineShape(b *Board, row, col, dr, dc int, p Stone) (run, open int) {
+	run = 1
+	for _, sign := range [2]int{1, -1} {
+		r, c := row+sign*dr, col+sign*dc
+		for b.OnBoard(r, c) && b.At(r, c) == p {
+			run++
+			r, c = r+sign*dr, c+sign*dc
+		}
+		if b.OnBoard(r, c) && b.At(r, c) == Empty {
+			open++
+		}
+	}
+	return run, open
+}
+
+// shapeScore scores a run with open empty ends in a game won by winLength
+// in a row, by how far the run is from winning. A run too long to win,
+// when overline is false, scores nothing.
+func shapeScore(run, open, winLength int, overline bool) int {
+	if run > winLength && !overline {
+		return 0
+	}
+	run += 5 - winLength
+	if run >= 5 {
+		return scoreFive
+	}
+	if open == 0 {
+		return 0
+	}
+	switch run {
+	case 4:
+		if open == 2 {
+			return scoreOpenFour
+		}
+		return scoreFour
+	case 3:
+		if open == 2 {
+			return scoreOpenThree
+		}
+		return scoreThree
+	case 2:
+		if open == 2 {
+			return scoreOpenTwo
+		}
+		return scoreTwo
+	}
+	return scoreOne
+}
+
+// makesWin reports whether p playing the empty point row, col completes a
+// winning line.
+func makesWin(b *Board, row, col int, p Stone) bool {
+	for _, d := range lineDirs {
+		if winsAlong(b, row, col, d, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// winsAlong reports whether p playing the empty point row, col completes a
+// winning line along d.
+func winsAlong(b *Board, row, col int, d [2]int, p Stone) bool {
+	run, ends := 1, [2]Stone{}
+	for i, sign := range [2]int{1, -1} {
+		r, c := row+sign*d[0], col+sign*d[1]
+		for b.OnBoard(r, c) && b.At(r, c) == p {
+			run++
+			r, c = r+sign*d[0], c+sign*d[1]
+		}
+		if b.OnBoard(r, c) {
+			ends[i] = b.At(r, c)
+		}
+	}
+	return b.Variant().WinsBetween(run, p, ends[0], ends[1])
+}
+
+// pointScore rates the empty point row, col for p by the lines it would
+// make for p and the lines it would take from the opponent. Attack weighs
+// a little more, so completing a five comes before blocking one.
+func pointScore(bb *Bitboard, row, col int, p Stone) int {
+	attack, defense := 0, 0
+	for dir := range lineDirs {
+		attack += lineScore(bb, dir, row, col, p)
+		defense += lineScore(bb, dir, row, col, 3-p)
+	}
+	return attack + defense*7/8
+}
+
+// lineScore scores the line p would make through the empty point row, col
+// in direction dir.
+func lineScore(bb *Bitboard, dir, row, col int, p Stone) int {
+	run, open := bb.Shape(dir, row, col, p)
+	score := shapeScore(run, open, bb.winLength, bb.overline[p])
+	if score == scoreFive && bb.closes && !bb.WinIfAlong(dir, row, col, p) {
+		return 0 // closed at both ends, so it never wins
+	}
+	return score
+}
+
+// expansionMoves lists the moves a node with p to play tries, in order.
+// When ordered, these are the points within two steps of a stone, best
+// pointScore first; otherwise every empty point in random order. Points
+// the rule forbids to p are left out.
+func expansionMoves(b Board, p Stone, ordered bool, rng *rand.Rand) [][2]int {
+	if !ordered {
+		return legalOnly(&b, legalMoves(b, rng), p)
+	}
+	moves := legalOnly(&b, localMoves(b, 2), p)
+	if len(moves) == 0 {
+		moves = legalOnly(&b, emptyPoints(b), p)
+	}
+	rng.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+
+	bb := NewBitboard(&b)
+	center := b.Size / 2
+	scores := make(map[[2]int]int, len(moves))
+	for _, m := range moves {
+		// Prefer the centre among equal points, as on an empty board.
+		scores[m] = pointScore(&bb, m[0], m[1], p) + center - max(abs(m[0]-center), abs(m[1]-center))
+	}
+	sort.SliceStable(moves, func(i, j int) bool { return scores[moves[i]] > scores[moves[j]] })
+	return moves
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
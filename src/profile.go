@@ -0,0 +1,89 @@
+package src
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// RolloutPolicy picks the moves of an MCTS playout.
+type RolloutPolicy int
+
+const (
+	// RolloutRandom plays uniformly over every empty point.
+	RolloutRandom RolloutPolicy = iota
+	// RolloutLocal plays next to the stones already on the board.
+	RolloutLocal
+	// RolloutHeuristic plays next to the stones but always completes a five
+	// and always blocks the opponent's.
+	RolloutHeuristic
+	rolloutPolicyCount
+)
+
+func (p RolloutPolicy) String() string {
+	switch p {
+	case RolloutRandom:
+		return "random"
+	case RolloutLocal:
+		return "local"
+	case RolloutHeuristic:
+		return "heuristic"
+	}
+	return fmt.Sprintf("RolloutPolicy(%d)", int(p))
+}
+
+func ParseRolloutPolicy(s string) (RolloutPolicy, error) {
+	for p := RolloutPolicy(0); p < rolloutPolicyCount; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rollout policy %q", s)
+}
+
+// SearchProfile is the budget and tuning of one MCTS search.
+type SearchProfile struct {
+	TimeLimit    time.Duration
+	Playouts     int // 0 for no cap
+	Exploration  float64
+	Rollout      RolloutPolicy
+	Workers      int  // 0 for one per CPU
+	PatternOrder bool // expand near moves by pattern score, not every move at random
+	ThreatCheck  bool // play a forced win found by the threat solver without searching
+	MaxNodes     int  // cap on the search tree; 0 for the default
+}
+
+func (p SearchProfile) String() string {
+	playouts := "unlimited"
+	if p.Playouts > 0 {
+		playouts = fmt.Sprint(p.Playouts)
+	}
+	order := "random"
+	if p.PatternOrder {
+		order = "pattern"
+	}
+	return fmt.Sprintf("%v, %s playouts, c=%.2f, %v rollouts, %s order, %d workers",
+		p.TimeLimit, playouts, p.Exploration, p.Rollout, order, p.workerCount())
+}
+
+func (p SearchProfile) workerCount() int {
+	if p.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return p.Workers
+}
+
+var profilePresets = map[DifficultyLevel]SearchProfile{
+	Easy:   {TimeLimit: 1 * time.Second, Exploration: 1.41, Rollout: RolloutRandom, Workers: 1},
+	Medium: {TimeLimit: 3 * time.Second, Exploration: 1.41, Rollout: RolloutRandom, Workers: 1},
+	Hard:   {TimeLimit: 3 * time.Second, Exploration: 1.0, Rollout: RolloutHeuristic, PatternOrder: true, ThreatCheck: true},
+	Expert: {TimeLimit: 8 * time.Second, Exploration: 0.8, Rollout: RolloutHeuristic, PatternOrder: true, ThreatCheck: true},
+}
+
+// Profile returns the preset for difficulty. Custom starts out as Medium.
+func Profile(difficulty DifficultyLevel) SearchProfile {
+	if p, ok := profilePresets[difficulty]; ok {
+		return p
+	}
+	return profilePresets[Medium]
+}
@@ -0,0 +1,248 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Opening is the protocol that balances the start of a game.
+type Opening int
+
+const (
+	// NoOpening lets Black start anywhere.
+	NoOpening Opening = iota
+	// Swap has the first player place two black stones and a white one,
+	// after which the second player picks a colour.
+	Swap
+	// Swap2 is Swap, except that the second player may instead place a
+	// white and a black stone more and leave the choice of colour to the
+	// first player.
+	Swap2
+	// Pro puts Black's first stone in the centre and its second at least
+	// three points from it.
+	Pro
+	// LongPro is Pro with the second black stone at least four points from
+	// the centre.
+	LongPro
+	openingCount
+)
+
+var openingNames = [openingCount]string{"none", "swap", "swap2", "pro", "longpro"}
+
+func (o Opening) String() string {
+	if o < 0 || o >= openingCount {
+		return fmt.Sprintf("Opening(%d)", int(o))
+	}
+	return openingNames[o]
+}
+
+// Openings lists every opening protocol, in order.
+func Openings() []Opening {
+	ops := make([]Opening, openingCount)
+	for i := range ops {
+		ops[i] = Opening(i)
+	}
+	return ops
+}
+
+func ParseOpening(name string) (Opening, error) {
+	for i, n := range openingNames {
+		if n == name {
+			return Opening(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown opening %q", name)
+}
+
+// swapStones is how many stones the first player places before the second
+// chooses a colour.
+const swapStones = 3
+
+// distance returns how far from the centre Black's second stone must be,
+// or 0 if the opening puts no limit on it.
+func (o Opening) distance() int {
+	switch o {
+	case Pro:
+		return 3
+	case LongPro:
+		return 4
+	}
+	return 0
+}
+
+// validate reports whether o can be played on v's board.
+func (o Opening) validate(v Variant) error {
+	switch {
+	case o < 0 || o >= openingCount:
+		return fmt.Errorf("unknown opening %d", int(o))
+	case (o == Swap || o == Swap2) && v.WinLength <= swapStones:
+		return fmt.Errorf("%v needs more than %d in a row", o, swapStones)
+	case v.Size/2 < o.distance():
+		return fmt.Errorf("%v needs a larger board than %dx%d", o, v.Size, v.Size)
+	}
+	return nil
+}
+
+// Side is one of the two players: First places the opening stones, or
+// plays Black when there is no colour to choose.
+type Side int
+
+const (
+	First Side = iota
+	Second
+)
+
+func (s Side) String() string {
+	if s == Second {
+		return "Second player"
+	}
+	return "First player"
+}
+
+// Other returns the other player.
+func (s Side) Other() Side {
+	return 1 - s
+}
+
+// Choice is a decision a player makes during a swap opening.
+type Choice int
+
+const (
+	TakeBlack Choice = iota
+	TakeWhite
+	// PlaceTwo places a white and a black stone more and hands the choice
+	// of colour to the other player.
+	PlaceTwo
+	choiceCount
+)
+
+var choiceNames = [choiceCount]string{"black", "white", "two"}
+
+func (c Choice) String() string {
+	if c < 0 || c >= choiceCount {
+		return fmt.Sprintf("Choice(%d)", int(c))
+	}
+	return choiceNames[c]
+}
+
+func ParseChoice(name string) (Choice, error) {
+	for i, n := range choiceNames {
+		if n == name {
+			return Choice(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown choice %q", name)
+}
+
+var (
+	ErrChoiceDue = errors.New("a choice of colour is due")
+	ErrNoChoice  = errors.New("no such choice is open")
+	ErrOpening   = errors.New("point breaks the opening")
+)
+
+// choice is a decision made in a game, after moves stones were placed.
+type choice struct {
+	moves int
+	by    Side
+	c     Choice
+}
+
+// Pending returns the choices open to Turn, or nil when a stone is due.
+func (p *Position) Pending() []Choice {
+	n, k := len(p.history), len(p.choices)
+	switch o := p.variant.Opening; {
+	case p.result != Ongoing:
+		return nil
+	case o == Swap && n == swapStones && k == 0:
+		return []Choice{TakeBlack, TakeWhite}
+	case o == Swap2 && n == swapStones && k == 0:
+		return []Choice{TakeBlack, TakeWhite, PlaceTwo}
+	case o == Swap2 && n == swapStones+2 && k == 1 && p.choices[0].c == PlaceTwo:
+		return []Choice{TakeBlack, TakeWhite}
+	}
+	return nil
+}
+
+// Turn returns the player to act: the one who places the next stone, which
+// during a swap opening need not be the one holding its colour, or the one
+// whose choice is due.
+func (p *Position) Turn() Side {
+	if o := p.variant.Opening; o == Swap || o == Swap2 {
+		n := len(p.history)
+		switch {
+		case n < swapStones:
+			return First
+		case len(p.choices) == 0:
+			return Second
+		case p.choices[0].c == PlaceTwo && n < swapStones+2:
+			return Second
+		case p.choices[0].c == PlaceTwo && len(p.choices) == 1:
+			return First
+		}
+	}
+	return p.SideOf(p.toMove)
+}
+
+// Decide makes the choice c for Turn.
+func (p *Position) Decide(c Choice) error {
+	by := p.Turn()
+	for _, open := range p.Pending() {
+		if open == c {
+			p.choices = append(p.choices, choice{moves: len(p.history), by: by, c: c})
+			return nil
+		}
+	}
+	return fmt.Errorf("%v chose %v: %w", by, c, ErrNoChoice)
+}
+
+// SideOf returns the player holding colour s. Until a colour is chosen the
+// first player holds Black.
+func (p *Position) SideOf(s Stone) Side {
+	if (s == Black) != p.swapped() {
+		return First
+	}
+	return Second
+}
+
+// StoneOf returns the colour side holds.
+func (p *Position) StoneOf(side Side) Stone {
+	if (side == First) != p.swapped() {
+		return Black
+	}
+	return White
+}
+
+// swapped reports whether a choice of colour left the first player with
+// White.
+func (p *Position) swapped() bool {
+	for i := len(p.choices) - 1; i >= 0; i-- {
+		if ch := p.choices[i]; ch.c != PlaceTwo {
+			return (ch.by == First) == (ch.c == TakeWhite)
+		}
+	}
+	return false
+}
+
+// openingAllows reports whether the opening lets the next stone go on
+// row, col.
+func (p *Position) openingAllows(row, col int) bool {
+	d := p.variant.Opening.distance()
+	if d == 0 {
+		return true
+	}
+	c := p.variant.Size / 2
+	switch len(p.history) {
+	case 0:
+		return row == c && col == c
+	case 2:
+		return max(abs(row-c), abs(col-c)) >= d
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
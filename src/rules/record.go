@@ -0,0 +1,108 @@
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Record returns the game as text: a line naming the variant, then one move
+// per line as row,col, oldest first, with opening choices as "choose
+// white" and the like between them, and last "resign black" or "draw" for
+// a game given up or drawn by agreement. ParseRecord reads it back.
+func (p *Position) Record() string {
+	var sb strings.Builder
+	v := p.variant
+	fmt.Fprintf(&sb, "variant %d %d %v %v\n", v.Size, v.WinLength, v.Rule, v.Opening)
+	k := 0
+	for i := 0; i <= len(p.history); i++ {
+		for ; k < len(p.choices) && p.choices[k].moves == i; k++ {
+			fmt.Fprintf(&sb, "choose %v\n", p.choices[k].c)
+		}
+		if i < len(p.history) {
+			fmt.Fprintf(&sb, "%d,%d\n", p.history[i][0], p.history[i][1])
+		}
+	}
+	switch {
+	case p.resigned != Empty:
+		fmt.Fprintf(&sb, "resign %s\n", strings.ToLower(p.resigned.String()))
+	case p.agreed:
+		sb.WriteString("draw\n")
+	}
+	return sb.String()
+}
+
+// Hash returns a hash of the record, the same for the same game on any
+// machine, so two copies of a game can be compared cheaply.
+func (p *Position) Hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(p.Record()))
+	return h.Sum64()
+}
+
+// ParseRecord replays a game written by Record, so a record with a move
+// the rules do not allow is rejected.
+func ParseRecord(text string) (*Position, error) {
+	sc := bufio.NewScanner(strings.NewReader(text))
+	if !sc.Scan() {
+		return nil, fmt.Errorf("record is empty")
+	}
+	var v Variant
+	var rule, opening string
+	if _, err := fmt.Sscanf(sc.Text(), "variant %d %d %s %s", &v.Size, &v.WinLength, &rule, &opening); err != nil {
+		return nil, fmt.Errorf("record variant %q: %w", sc.Text(), err)
+	}
+	var err error
+	if v.Rule, err = ParseRule(rule); err != nil {
+		return nil, err
+	}
+	if v.Opening, err = ParseOpening(opening); err != nil {
+		return nil, err
+	}
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := NewPosition(v)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		switch line {
+		case "resign black", "resign white":
+			s := Black
+			if line == "resign white" {
+				s = White
+			}
+			if err := p.Resign(s); err != nil {
+				return nil, err
+			}
+			continue
+		case "draw":
+			if err := p.AgreeDraw(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if name, ok := strings.CutPrefix(line, "choose "); ok {
+			c, err := ParseChoice(name)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.Decide(c); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var row, col int
+		if _, err := fmt.Sscanf(line, "%d,%d", &row, &col); err != nil {
+			return nil, fmt.Errorf("record move %q: %w", line, err)
+		}
+		if err := p.Play(row, col); err != nil {
+			return nil, err
+		}
+	}
+	return p, sc.Err()
+}
@@ -0,0 +1,157 @@
+package rules
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExactFive(t *testing.T) {
+	standard := Variant{Size: 15, WinLength: 5, Rule: ExactFive}
+	for _, tc := range []struct {
+		name string
+		row  string // the row the stone at * is played into
+		wins bool
+	}{
+		{"five", ".XXXX*.....", true},
+		{"five at the edge", "XXXX*......", true},
+		{"six", ".XXXX*X....", false},
+		{"six joined in the middle", ".XX*XXX....", false},
+		{"seven", "XXX*XXX....", false},
+		{"five next to a gap", ".XXXX*.X...", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, s := range []Stone{Black, White} {
+				p := NewPosition(standard)
+				star := strings.IndexByte(tc.row, '*')
+				for c, ch := range tc.row {
+					if ch == 'X' {
+						place(p, s, [2]int{7, c})
+					}
+				}
+				p.toMove = s
+				play(t, p, [2]int{7, star})
+				if got := p.Result() != Ongoing; got != tc.wins {
+					t.Errorf("%v: result %v, want a win %v", s, p.Result(), tc.wins)
+				}
+			}
+		})
+	}
+
+	// Freestyle counts the same six.
+	p := NewPosition(Standard)
+	place(p, Black, [2]int{7, 1}, [2]int{7, 2}, [2]int{7, 3}, [2]int{7, 4}, [2]int{7, 6})
+	play(t, p, [2]int{7, 5})
+	if p.Result() != BlackWins || len(p.WinningLine()) != 6 {
+		t.Errorf("freestyle six: result %v, line %v", p.Result(), p.WinningLine())
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		variant Variant
+		build   func(t *testing.T, p *Position)
+		ending  string
+	}{
+		{"moves", Standard, func(t *testing.T, p *Position) {
+			play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{8, 8})
+		}, ""},
+		{"a win", Variant{Size: 8, WinLength: 4, Rule: ExactFive}, func(t *testing.T, p *Position) {
+			play(t, p, [2]int{0, 0}, [2]int{1, 0}, [2]int{0, 1}, [2]int{1, 1}, [2]int{0, 2}, [2]int{1, 2}, [2]int{0, 3})
+		}, "4 in a row"},
+		{"swap2 choices", Variant{Size: 15, WinLength: 5, Opening: Swap2}, func(t *testing.T, p *Position) {
+			play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{8, 7})
+			p.Decide(PlaceTwo)
+			play(t, p, [2]int{9, 9}, [2]int{3, 3})
+			p.Decide(TakeWhite)
+			play(t, p, [2]int{6, 6})
+		}, ""},
+		{"resign", Variant{Size: 15, WinLength: 5, Opening: Swap}, func(t *testing.T, p *Position) {
+			play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{8, 7})
+			p.Decide(TakeBlack)
+			play(t, p, [2]int{1, 1})
+			if err := p.Resign(White); err != nil {
+				t.Fatal(err)
+			}
+		}, "White resigned"},
+		{"draw", Variant{Size: 19, WinLength: 6, Rule: Connect6}, func(t *testing.T, p *Position) {
+			play(t, p, [2]int{9, 9}, [2]int{9, 10}, [2]int{10, 10})
+			if err := p.AgreeDraw(); err != nil {
+				t.Fatal(err)
+			}
+		}, "draw agreed"},
+		{"captures", Variant{Size: 15, WinLength: 5, Rule: Pente}, func(t *testing.T, p *Position) {
+			play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{0, 0}, [2]int{7, 9}, [2]int{7, 10})
+		}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewPosition(tc.variant)
+			tc.build(t, p)
+			q, err := ParseRecord(p.Record())
+			if err != nil {
+				t.Fatalf("ParseRecord: %v\n%s", err, p.Record())
+			}
+			if q.Record() != p.Record() || q.Hash() != p.Hash() {
+				t.Errorf("record changed:\n%s\nread back as\n%s", p.Record(), q.Record())
+			}
+			if q.Variant() != p.Variant() || q.Result() != p.Result() || q.Ending() != tc.ending ||
+				q.ToMove() != p.ToMove() || q.Turn() != p.Turn() || !slices.Equal(q.cells, p.cells) {
+				t.Errorf("read back %v (%s), %v to move; want %v (%s), %v to move",
+					q.Result(), q.Ending(), q.ToMove(), p.Result(), tc.ending, p.ToMove())
+			}
+		})
+	}
+}
+
+func TestRecordFormat(t *testing.T) {
+	p := NewPosition(Variant{Size: 15, WinLength: 5, Opening: Swap})
+	play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{8, 7})
+	p.Decide(TakeWhite)
+	p.Resign(Black)
+	want := "variant 15 5 freestyle swap\n7,7\n7,8\n8,7\nchoose white\nresign black\n"
+	if got := p.Record(); got != want {
+		t.Errorf("Record() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseRecordRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		want error
+	}{
+		{"an occupied point", "variant 15 5 freestyle none\n7,7\n7,7\n", ErrOccupied},
+		{"a point off the board", "variant 15 5 freestyle none\n7,15\n", ErrOffBoard},
+		{"a move after the win", "variant 15 5 freestyle none\n" +
+			"0,0\n1,0\n0,1\n1,1\n0,2\n1,2\n0,3\n1,3\n0,4\n5,5\n", ErrGameOver},
+		{"a renju foul", "variant 15 5 renju none\n" +
+			"7,5\n0,0\n7,6\n0,2\n5,7\n0,4\n6,7\n0,6\n7,7\n", ErrForbidden},
+		{"a move before a choice", "variant 15 5 freestyle swap\n7,7\n7,8\n8,7\n1,1\n", ErrChoiceDue},
+		{"a choice nobody has", "variant 15 5 freestyle none\n7,7\nchoose white\n", ErrNoChoice},
+		{"a resignation after the win", "variant 15 5 freestyle none\n" +
+			"0,0\n1,0\n0,1\n1,1\n0,2\n1,2\n0,3\n1,3\n0,4\nresign white\n", ErrGameOver},
+		{"the pro opening off centre", "variant 15 5 freestyle pro\n3,3\n", ErrOpening},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseRecord(tc.text); !errors.Is(err, tc.want) {
+				t.Errorf("ParseRecord = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	for _, text := range []string{
+		"",
+		"7,7\n",
+		"variant 15 5 gomoku none\n",
+		"variant 15 5 freestyle sideways\n",
+		"variant 3 5 freestyle none\n",
+		"variant 15 5 freestyle none\nseven,seven\n",
+		"variant 15 5 freestyle swap\n7,7\n7,8\n8,7\nchoose grey\n",
+	} {
+		if _, err := ParseRecord(text); err == nil {
+			t.Errorf("ParseRecord(%q) accepted it", text)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package rules
+
+// Grid is a board the Renju checks can read and try stones on. Setting a
+// point back to Empty takes a trial stone away again.
+type Grid interface {
+	OnBoard(row, col int) bool
+	At(row, col int) Stone
+	Set(row, col int, s Stone)
+}
+
+// maxThreeDepth bounds how many threes deep Forbidden follows the question
+// of whether a three is real. Deeper chains are taken to be real threes.
+const maxThreeDepth = 6
+
+// Forbidden reports whether Black playing the empty point row, col on g
+// makes an overline, two fours or two threes. A move that makes exactly
+// five is never forbidden. A three only counts when it can become a
+// straight four by a move that is not itself forbidden, so fake threes do
+// not make a double three.
+func Forbidden(g Grid, row, col int) bool {
+	return forbidden(g, row, col, 0)
+}
+
+func forbidden(g Grid, row, col, depth int) bool {
+	if !crowded(g, row, col) {
+		return false
+	}
+	g.Set(row, col, Black)
+	defer g.Set(row, col, Empty)
+
+	overline := false
+	for _, d := range directions {
+		switch lo, hi := runBounds(g, row, col, d); {
+		case hi-lo+1 == 5:
+			return false
+		case hi-lo+1 > 5:
+			overline = true
+		}
+	}
+	if overline {
+		return true
+	}
+
+	fours, threes := 0, 0
+	for _, d := range directions {
+		if n := foursAlong(g, row, col, d); n > 0 {
+			fours += n
+		} else if depth < maxThreeDepth && realThree(g, row, col, d, depth) {
+			threes++
+		}
+	}
+	return fours >= 2 || threes >= 2
+}
+
+// crowded reports whether enough black stones lie near row, col for a move
+// there to be forbidden: two lines with two stones each for a double
+// three, or four in one line for an overline or two fours in a line.
+func crowded(g Grid, row, col int) bool {
+	lines := 0
+	for _, d := range directions {
+		n := 0
+		for k := -5; k <= 5; k++ {
+			if r, c := row+k*d[0], col+k*d[1]; k != 0 && g.OnBoard(r, c) && g.At(r, c) == Black {
+				n++
+			}
+		}
+		if n >= 4 {
+			return true
+		}
+		if n >= 2 {
+			lines++
+		}
+	}
+	return lines >= 2
+}
+
+// runBounds returns how far the run of black stones through row, col
+// reaches along d, in steps: lo back and hi forward.
+func runBounds(g Grid, row, col int, d [2]int) (lo, hi int) {
+	for r, c := row-d[0], col-d[1]; g.OnBoard(r, c) && g.At(r, c) == Black; r, c = r-d[0], c-d[1] {
+		lo--
+	}
+	for r, c := row+d[0], col+d[1]; g.OnBoard(r, c) && g.At(r, c) == Black; r, c = r+d[0], c+d[1] {
+		hi++
+	}
+	return lo, hi
+}
+
+// fivePointsAlong returns the steps along d from the black stone at row,
+// col to the empty points where Black would make exactly five including
+// it.
+func fivePointsAlong(g Grid, row, col int, d [2]int) []int {
+	var ks []int
+	for k := -4; k <= 4; k++ {
+		r, c := row+k*d[0], col+k*d[1]
+		if k == 0 || !g.OnBoard(r, c) || g.At(r, c) != Empty {
+			continue
+		}
+		g.Set(r, c, Black)
+		lo, hi := runBounds(g, r, c, d)
+		g.Set(r, c, Empty)
+		if hi-lo+1 == 5 && lo <= -k && -k <= hi {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// foursAlong counts the fours through the black stone at row, col along d.
+// A straight four has two five points but is one four; two five points
+// further apart are two fours in the same line.
+func foursAlong(g Grid, row, col int, d [2]int) int {
+	ks := fivePointsAlong(g, row, col, d)
+	if len(ks) == 2 && ks[1]-ks[0] == 5 {
+		return 1
+	}
+	return len(ks)
+}
+
+// realThree reports whether the black stone at row, col is part of a three
+// along d: a line that one more black stone, on a point not forbidden,
+// turns into a straight four.
+func realThree(g Grid, row, col int, d [2]int, depth int) bool {
+	for k := -4; k <= 4; k++ {
+		r, c := row+k*d[0], col+k*d[1]
+		if k == 0 || !g.OnBoard(r, c) || g.At(r, c) != Empty {
+			continue
+		}
+		g.Set(r, c, Black)
+		ks := fivePointsAlong(g, row, col, d)
+		g.Set(r, c, Empty)
+		if len(ks) == 2 && ks[1]-ks[0] == 5 && !forbidden(g, r, c, depth+1) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+)
+
+var renju = Variant{Size: 15, WinLength: 5, Rule: Renju}
+
+// renjuBoard builds a Renju position from rows drawn with X for Black, O for
+// White and * for the point in question. The drawing is set two points in
+// from the corner, so the edge of the board plays no part.
+func renjuBoard(t *testing.T, rows ...string) (p *Position, row, col int) {
+	t.Helper()
+	p = NewPosition(renju)
+	row, col = -1, -1
+	for r, line := range rows {
+		for c, ch := range line {
+			pt := [2]int{r + 2, c + 2}
+			switch ch {
+			case 'X':
+				place(p, Black, pt)
+			case 'O':
+				place(p, White, pt)
+			case '*':
+				row, col = pt[0], pt[1]
+			case '.':
+			default:
+				t.Fatalf("unknown point %q", ch)
+			}
+		}
+	}
+	if row < 0 {
+		t.Fatal("no point marked")
+	}
+	return p, row, col
+}
+
+func TestForbidden(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rows  []string
+		fouls bool
+	}{
+		{"double three", []string{
+			"...........",
+			"...X.......",
+			"...X.......",
+			".XX*.......",
+			"...........",
+		}, true},
+		{"double three on the diagonals", []string{
+			"...........",
+			".X...X.....",
+			"..X.X......",
+			"...*.......",
+			"...........",
+		}, true},
+		{"a three closed at one end", []string{
+			"...........",
+			"...X.......",
+			"...X.......",
+			"OXX*.......",
+			"...........",
+		}, false},
+		{"four and three", []string{
+			"...........",
+			"...X.......",
+			"...X.......",
+			"...X.......",
+			".XX*.......",
+			"...........",
+		}, false},
+		{"double four", []string{
+			"...........",
+			"...X.......",
+			"...X.......",
+			"...X.......",
+			"XXX*.......",
+			"...........",
+		}, true},
+		{"double four in one line", []string{
+			"...........",
+			".X.X*X.X...",
+			"...........",
+		}, true},
+		{"a closed four and a four", []string{
+			"...........",
+			"...O.......",
+			"...X.......",
+			"...X.......",
+			"...X.......",
+			"XXX*.......",
+			"...........",
+		}, true},
+		{"overline", []string{
+			"...........",
+			".XXX*XX....",
+			"...........",
+		}, true},
+		{"overline of seven", []string{
+			"...........",
+			".XXX*XXX...",
+			"...........",
+		}, true},
+		// The row's three can only become a straight four at the point left
+		// of *, where Black would make six in the column, so it is no three
+		// and * makes a single one, in the column.
+		{"false three through an overline point", []string{
+			"...........",
+			"...........",
+			"....X......",
+			"....XX.....",
+			"....XX.....",
+			".....*XX.O.",
+			"....X......",
+			"....X......",
+			"...........",
+		}, false},
+		{"the same threes with the point free", []string{
+			"...........",
+			"...........",
+			"...........",
+			".....X.....",
+			".....X.....",
+			".....*XX.O.",
+			"...........",
+			"...........",
+			"...........",
+		}, true},
+		{"five beats an overline", []string{
+			".....X...",
+			".....X...",
+			".....X...",
+			".XXXX*...",
+			".....X...",
+			".....X...",
+			".........",
+		}, false},
+		{"five beats a double three", []string{
+			".........",
+			".....X...",
+			".....X...",
+			".XXXX*...",
+			"......X..",
+			".......X.",
+			".........",
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, row, col := renjuBoard(t, tc.rows...)
+			if got := p.Forbidden(row, col); got != tc.fouls {
+				t.Errorf("Forbidden(%d, %d) = %v, want %v", row, col, got, tc.fouls)
+			}
+			if p.Legal(row, col) == tc.fouls {
+				t.Errorf("Legal(%d, %d) = %v", row, col, !tc.fouls)
+			}
+			if p.At(row, col) != Empty {
+				t.Error("the trial stone was left on the board")
+			}
+		})
+	}
+}
+
+func TestRenjuResults(t *testing.T) {
+	t.Run("five with a foul wins", func(t *testing.T) {
+		p, row, col := renjuBoard(t,
+			".....X...",
+			".....X...",
+			".....X...",
+			".XXXX*...",
+			".....X...",
+			".....X...",
+		)
+		play(t, p, [2]int{row, col})
+		if p.Result() != BlackWins || len(p.WinningLine()) != 5 {
+			t.Errorf("result %v, line %v", p.Result(), p.WinningLine())
+		}
+	})
+
+	t.Run("black overline is refused", func(t *testing.T) {
+		p, row, col := renjuBoard(t, ".XXX*XX....")
+		if err := p.Play(row, col); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Play = %v, want %v", err, ErrForbidden)
+		}
+	})
+
+	t.Run("white overline wins", func(t *testing.T) {
+		p, row, col := renjuBoard(t, ".OOO*OO....")
+		p.toMove = White
+		play(t, p, [2]int{row, col})
+		if p.Result() != WhiteWins || len(p.WinningLine()) != 6 {
+			t.Errorf("result %v, line %v", p.Result(), p.WinningLine())
+		}
+	})
+
+	t.Run("white may play black's foul", func(t *testing.T) {
+		p, row, col := renjuBoard(t,
+			"...........",
+			"...X.......",
+			"...X.......",
+			".XX*.......",
+		)
+		p.toMove = White
+		if !p.Legal(row, col) {
+			t.Error("a point forbidden to Black is refused to White")
+		}
+	})
+}
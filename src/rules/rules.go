@@ -0,0 +1,580 @@
+// Package rules holds the rules of Gomoku apart from the UI, so that tools,
+// engines and servers can referee games without pulling in Ebiten.
+package rules
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Stone int
+
+const (
+	Empty Stone = iota
+	Black
+	White
+)
+
+func (s Stone) String() string {
+	switch s {
+	case Black:
+		return "Black"
+	case White:
+		return "White"
+	}
+	return "Empty"
+}
+
+// Opponent returns the other colour.
+func (s Stone) Opponent() Stone {
+	return 3 - s
+}
+
+// Result is the state of a game.
+type Result int
+
+const (
+	Ongoing Result = iota
+	BlackWins
+	WhiteWins
+	Draw
+)
+
+func (r Result) String() string {
+	switch r {
+	case BlackWins:
+		return "Black wins"
+	case WhiteWins:
+		return "White wins"
+	case Draw:
+		return "Draw"
+	}
+	return "Ongoing"
+}
+
+// Winner returns the winning colour, or Empty for a draw or a game still
+// being played.
+func (r Result) Winner() Stone {
+	switch r {
+	case BlackWins:
+		return Black
+	case WhiteWins:
+		return White
+	}
+	return Empty
+}
+
+var (
+	ErrOffBoard  = errors.New("point is off the board")
+	ErrOccupied  = errors.New("point is occupied")
+	ErrGameOver  = errors.New("game is over")
+	ErrNoHistory = errors.New("no moves to undo")
+	ErrForbidden = errors.New("point is forbidden for Black")
+)
+
+var directions = [4][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+
+// MaxSize is the largest board a Variant may use.
+const MaxSize = 19
+
+// Rule is the rule set a game is played under.
+type Rule int
+
+const (
+	// Freestyle wins with a line of WinLength or more, for either side.
+	Freestyle Rule = iota
+	// Renju forbids Black double threes, double fours and overlines, and
+	// only an exact five wins for Black. White wins with five or more.
+	Renju
+	// ExactFive is standard Gomoku: only a line of exactly WinLength wins,
+	// for either side, and longer lines count for nothing.
+	ExactFive
+	// Pente is freestyle with custodial captures of two stones. Ten
+	// captured stones win as well as a line.
+	Pente
+	// Keryo is Pente that also captures three stones, and wins at fifteen.
+	Keryo
+	// Connect6 has Black place one stone, then each side two a turn, and
+	// six or more in a row win.
+	Connect6
+	// Caro is freestyle, except that a line closed off at both ends by the
+	// opponent's stones does not win. The edge of the board closes nothing.
+	Caro
+	ruleCount
+)
+
+var ruleNames = [ruleCount]string{"freestyle", "renju", "standard", "pente", "keryo", "connect6", "caro"}
+
+func (r Rule) String() string {
+	if r < 0 || r >= ruleCount {
+		return fmt.Sprintf("Rule(%d)", int(r))
+	}
+	return ruleNames[r]
+}
+
+// Rules lists every rule set, in order.
+func Rules() []Rule {
+	rs := make([]Rule, ruleCount)
+	for i := range rs {
+		rs[i] = Rule(i)
+	}
+	return rs
+}
+
+func ParseRule(name string) (Rule, error) {
+	for i, n := range ruleNames {
+		if n == name {
+			return Rule(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rule %q", name)
+}
+
+// OverlineWins reports whether a line longer than WinLength wins for s.
+func (r Rule) OverlineWins(s Stone) bool {
+	switch r {
+	case Renju:
+		return s == White
+	case ExactFive:
+		return false
+	}
+	return true
+}
+
+// Restricts reports whether some empty points are forbidden to s.
+func (r Rule) Restricts(s Stone) bool {
+	return r == Renju && s == Black
+}
+
+// StonesPerTurn returns how many stones a player places in a turn, after
+// Black's first stone, which is always one.
+func (r Rule) StonesPerTurn() int {
+	if r == Connect6 {
+		return 2
+	}
+	return 1
+}
+
+// Next returns who places the stone after s placed stone number placed of
+// the game, counting from 1.
+func (r Rule) Next(s Stone, placed int) Stone {
+	if r.StonesPerTurn() == 2 && placed%2 == 0 {
+		return s
+	}
+	return s.Opponent()
+}
+
+// CaptureGoal returns how many captured stones win, or 0 if the rule does
+// not capture.
+func (r Rule) CaptureGoal() int {
+	switch r {
+	case Pente:
+		return 10
+	case Keryo:
+		return 15
+	}
+	return 0
+}
+
+// captureLengths lists how many stones in a row the rule captures.
+func (r Rule) captureLengths() []int {
+	switch r {
+	case Pente:
+		return []int{2}
+	case Keryo:
+		return []int{2, 3}
+	}
+	return nil
+}
+
+// Captures returns the opponent stones that s, just placed on row, col of
+// g, captures: a run of a capturing length closed off at its far end by
+// another stone of s. It does not take them off g.
+func (r Rule) Captures(g Grid, row, col int, s Stone) [][2]int {
+	var taken [][2]int
+	for _, d := range directions {
+		for _, sign := range [2]int{1, -1} {
+			dr, dc := sign*d[0], sign*d[1]
+		lengths:
+			for _, n := range r.captureLengths() {
+				if er, ec := row+(n+1)*dr, col+(n+1)*dc; !g.OnBoard(er, ec) || g.At(er, ec) != s {
+					continue
+				}
+				for k := 1; k <= n; k++ {
+					if g.At(row+k*dr, col+k*dc) != s.Opponent() {
+						continue lengths
+					}
+				}
+				for k := 1; k <= n; k++ {
+					taken = append(taken, [2]int{row + k*dr, col + k*dc})
+				}
+			}
+		}
+	}
+	return taken
+}
+
+// Variant is the board, the line length, the rule set and the opening a
+// game is played with.
+type Variant struct {
+	Size      int
+	WinLength int
+	Rule      Rule
+	Opening   Opening
+}
+
+// Standard is five in a row on the 15x15 board.
+var Standard = Variant{Size: 15, WinLength: 5}
+
+func (v Variant) String() string {
+	s := fmt.Sprintf("%dx%d, %d in a row", v.Size, v.Size, v.WinLength)
+	if v.Rule != Freestyle {
+		s += ", " + v.Rule.String()
+	}
+	if v.Opening != NoOpening {
+		s += ", " + v.Opening.String()
+	}
+	return s
+}
+
+// Validate reports whether v can be played.
+func (v Variant) Validate() error {
+	switch {
+	case v.Size < 5 || v.Size > MaxSize:
+		return fmt.Errorf("board size %d is not between 5 and %d", v.Size, MaxSize)
+	case v.WinLength < 3 || v.WinLength > v.Size:
+		return fmt.Errorf("win length %d does not fit a %dx%d board", v.WinLength, v.Size, v.Size)
+	case v.Rule < 0 || v.Rule >= ruleCount:
+		return fmt.Errorf("unknown rule %d", int(v.Rule))
+	case v.Rule == Renju && v.WinLength != 5:
+		return errors.New("renju is played five in a row")
+	case v.Rule == Connect6 && v.WinLength != 6:
+		return errors.New("connect6 is played six in a row")
+	case v.Rule == Connect6 && v.Opening != NoOpening:
+		return errors.New("connect6 has no opening")
+	}
+	return v.Opening.validate(v)
+}
+
+// Wins reports whether a run of s stones wins.
+func (v Variant) Wins(run int, s Stone) bool {
+	return run == v.WinLength || run > v.WinLength && v.Rule.OverlineWins(s)
+}
+
+// WinsBetween reports whether a run of s stones wins with before and after
+// on the points just past its ends, Empty for a free point or the edge of
+// the board.
+func (v Variant) WinsBetween(run int, s, before, after Stone) bool {
+	return v.Wins(run, s) && !v.Rule.Closes(s, before, after)
+}
+
+// Closes reports whether before and after, just past the ends of a line of
+// s, keep it from winning.
+func (r Rule) Closes(s, before, after Stone) bool {
+	return r == Caro && before == s.Opponent() && after == s.Opponent()
+}
+
+// Position is a game in progress: the stones on a square board, the side to
+// move and the moves and opening choices that led here. A line the
+// variant's rule accepts wins; a full board without one is a draw.
+type Position struct {
+	variant Variant
+	cells   []Stone
+	toMove  Stone
+	history [][2]int
+	taken   [][][2]int // the stones each move captured
+	choices []choice
+	result  Result
+	line    [][2]int
+	// captured counts the stones each colour has captured.
+	captured [3]int
+	// resigned is the colour that gave the game up, and agreed is set for
+	// a draw the players agreed to.
+	resigned Stone
+	agreed   bool
+}
+
+// NewPosition returns the empty board of v, with Black to move. v must be
+// valid.
+func NewPosition(v Variant) *Position {
+	return &Position{
+		variant: v,
+		cells:   make([]Stone, v.Size*v.Size),
+		toMove:  Black,
+	}
+}
+
+func (p *Position) Variant() Variant { return p.variant }
+func (p *Position) Size() int        { return p.variant.Size }
+func (p *Position) ToMove() Stone    { return p.toMove }
+func (p *Position) MoveCount() int   { return len(p.history) }
+func (p *Position) Result() Result   { return p.result }
+
+// Captured returns how many stones s has captured.
+func (p *Position) Captured(s Stone) int { return p.captured[s] }
+
+func (p *Position) onBoard(row, col int) bool {
+	return row >= 0 && row < p.variant.Size && col >= 0 && col < p.variant.Size
+}
+
+// At returns the stone on row, col, or Empty off the board.
+func (p *Position) At(row, col int) Stone {
+	if !p.onBoard(row, col) {
+		return Empty
+	}
+	return p.cells[row*p.variant.Size+col]
+}
+
+// History returns the moves played so far, oldest first.
+func (p *Position) History() [][2]int {
+	return append([][2]int(nil), p.history...)
+}
+
+// LastMove returns the most recent move, or false before the first.
+func (p *Position) LastMove() ([2]int, bool) {
+	if len(p.history) == 0 {
+		return [2]int{-1, -1}, false
+	}
+	return p.history[len(p.history)-1], true
+}
+
+// WinningLine returns the stones of the line that ended the game, or nil
+// if nobody has won by a line.
+func (p *Position) WinningLine() [][2]int {
+	return append([][2]int(nil), p.line...)
+}
+
+// Check returns why the side to move cannot play row, col, or nil if it
+// can.
+func (p *Position) Check(row, col int) error {
+	switch {
+	case p.result != Ongoing:
+		return ErrGameOver
+	case len(p.Pending()) > 0:
+		return ErrChoiceDue
+	case !p.onBoard(row, col):
+		return ErrOffBoard
+	case p.cells[row*p.variant.Size+col] != Empty:
+		return ErrOccupied
+	case !p.openingAllows(row, col):
+		return ErrOpening
+	case p.variant.Rule.Restricts(p.toMove) && Forbidden(grid{p}, row, col):
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Forbidden reports whether the empty point row, col is forbidden to Black
+// under the position's rule.
+func (p *Position) Forbidden(row, col int) bool {
+	return p.variant.Rule.Restricts(Black) && p.onBoard(row, col) &&
+		p.At(row, col) == Empty && Forbidden(grid{p}, row, col)
+}
+
+// Legal reports whether the side to move may play row, col.
+func (p *Position) Legal(row, col int) bool {
+	return p.Check(row, col) == nil
+}
+
+// Play puts a stone for the side to move on row, col and passes the turn.
+func (p *Position) Play(row, col int) error {
+	if err := p.Check(row, col); err != nil {
+		return fmt.Errorf("%v at %d,%d: %w", p.toMove, row, col, err)
+	}
+	s := p.toMove
+	p.cells[row*p.variant.Size+col] = s
+	taken := p.variant.Rule.Captures(grid{p}, row, col, s)
+	for _, t := range taken {
+		p.cells[t[0]*p.variant.Size+t[1]] = Empty
+	}
+	p.captured[s] += len(taken)
+	p.history = append(p.history, [2]int{row, col})
+	p.taken = append(p.taken, taken)
+	p.toMove = p.variant.Rule.Next(s, len(p.history))
+
+	line := p.winningLine(row, col, s)
+	goal := p.variant.Rule.CaptureGoal()
+	switch {
+	case line != nil || goal > 0 && p.captured[s] >= goal:
+		p.line = line
+		p.result = BlackWins
+		if s == White {
+			p.result = WhiteWins
+		}
+	case len(p.history)-p.captured[Black]-p.captured[White] == len(p.cells):
+		p.result = Draw
+	}
+	return nil
+}
+
+// Resign ends the game with s giving it up.
+func (p *Position) Resign(s Stone) error {
+	if p.result != Ongoing {
+		return ErrGameOver
+	}
+	p.resigned = s
+	p.result = BlackWins
+	if s == Black {
+		p.result = WhiteWins
+	}
+	return nil
+}
+
+// AgreeDraw ends the game in a draw the players agreed to.
+func (p *Position) AgreeDraw() error {
+	if p.result != Ongoing {
+		return ErrGameOver
+	}
+	p.agreed = true
+	p.result = Draw
+	return nil
+}
+
+// Ending says how the game ended, or returns "" for a game still being
+// played.
+func (p *Position) Ending() string {
+	switch {
+	case p.result == Ongoing:
+		return ""
+	case p.resigned != Empty:
+		return p.resigned.String() + " resigned"
+	case p.agreed:
+		return "draw agreed"
+	case p.line != nil:
+		return fmt.Sprintf("%d in a row", len(p.line))
+	case p.result == Draw:
+		return "board full"
+	}
+	return fmt.Sprintf("%d stones captured", p.captured[p.result.Winner()])
+}
+
+// Undo takes back a resignation or agreed draw, else the last move, or the
+// opening choice made after it.
+func (p *Position) Undo() error {
+	if p.resigned != Empty || p.agreed {
+		p.resigned, p.agreed = Empty, false
+		p.result = Ongoing
+		return nil
+	}
+	if k := len(p.choices); k > 0 && p.choices[k-1].moves == len(p.history) {
+		p.choices = p.choices[:k-1]
+		return nil
+	}
+	last, ok := p.LastMove()
+	if !ok {
+		return ErrNoHistory
+	}
+	s := p.cells[last[0]*p.variant.Size+last[1]]
+	p.cells[last[0]*p.variant.Size+last[1]] = Empty
+	taken := p.taken[len(p.taken)-1]
+	for _, t := range taken {
+		p.cells[t[0]*p.variant.Size+t[1]] = s.Opponent()
+	}
+	p.captured[s] -= len(taken)
+	p.history = p.history[:len(p.history)-1]
+	p.taken = p.taken[:len(p.taken)-1]
+	p.toMove = s
+	// Only the last move can have ended the game.
+	p.result = Ongoing
+	p.line = nil
+	return nil
+}
+
+// UndoTurn takes back the last turn: both stones of a two-stone turn, or
+// what Undo takes back.
+func (p *Position) UndoTurn() error {
+	if p.resigned != Empty || p.agreed {
+		return p.Undo()
+	}
+	n, k := len(p.history), len(p.choices)
+	if err := p.Undo(); err != nil {
+		return err
+	}
+	if len(p.choices) == k && n > 1 && p.turnOf(n-2) == p.turnOf(n-1) {
+		return p.Undo()
+	}
+	return nil
+}
+
+// StonesLeft returns how many stones the side to move still places this
+// turn.
+func (p *Position) StonesLeft() int {
+	if n := len(p.history); p.variant.Rule.StonesPerTurn() == 2 && n%2 == 1 {
+		return 2
+	}
+	return 1
+}
+
+// turnOf returns the turn the i-th move of the game, from 0, belongs to.
+func (p *Position) turnOf(i int) int {
+	if p.variant.Rule.StonesPerTurn() == 2 {
+		return (i + 1) / 2
+	}
+	return i
+}
+
+// Clone returns an independent copy of p.
+func (p *Position) Clone() *Position {
+	c := *p
+	c.cells = append([]Stone(nil), p.cells...)
+	c.history = append([][2]int(nil), p.history...)
+	c.taken = append([][][2]int(nil), p.taken...)
+	c.choices = append([]choice(nil), p.choices...)
+	c.line = append([][2]int(nil), p.line...)
+	return &c
+}
+
+// winningLine returns a run of s through row, col that wins, in board
+// order, or nil.
+func (p *Position) winningLine(row, col int, s Stone) [][2]int {
+	for _, d := range directions {
+		line, before, after := p.lineThrough(row, col, d, s)
+		if p.variant.WinsBetween(len(line), s, p.At(before[0], before[1]), p.At(after[0], after[1])) {
+			return line
+		}
+	}
+	return nil
+}
+
+// ClosedLine returns a line through the last move long enough to win that
+// did not, because the opponent's stones close both its ends, and those
+// two stones. It returns nil if the last move made no such line.
+func (p *Position) ClosedLine() (line [][2]int, ends [2][2]int) {
+	last, ok := p.LastMove()
+	if !ok {
+		return nil, ends
+	}
+	s := p.At(last[0], last[1])
+	for _, d := range directions {
+		line, before, after := p.lineThrough(last[0], last[1], d, s)
+		if p.variant.Wins(len(line), s) &&
+			p.variant.Rule.Closes(s, p.At(before[0], before[1]), p.At(after[0], after[1])) {
+			return line, [2][2]int{before, after}
+		}
+	}
+	return nil, ends
+}
+
+// lineThrough returns the run of s through row, col along d in board order,
+// and the points just before and after it, which may be off the board.
+func (p *Position) lineThrough(row, col int, d [2]int, s Stone) (line [][2]int, before, after [2]int) {
+	r, c := row, col
+	for p.At(r-d[0], c-d[1]) == s {
+		r, c = r-d[0], c-d[1]
+	}
+	before = [2]int{r - d[0], c - d[1]}
+	for ; p.At(r, c) == s; r, c = r+d[0], c+d[1] {
+		line = append(line, [2]int{r, c})
+	}
+	return line, before, [2]int{r, c}
+}
+
+// grid lets the rule checks try stones on a position.
+type grid struct{ p *Position }
+
+func (g grid) OnBoard(row, col int) bool { return g.p.onBoard(row, col) }
+func (g grid) At(row, col int) Stone     { return g.p.cells[row*g.p.variant.Size+col] }
+
+func (g grid) Set(row, col int, s Stone) {
+	g.p.cells[row*g.p.variant.Size+col] = s
+}
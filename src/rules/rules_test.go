@@ -0,0 +1,470 @@
+package rules
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// place puts stones on p without playing them, to build a position.
+func place(p *Position, s Stone, points ...[2]int) {
+	for _, pt := range points {
+		p.cells[pt[0]*p.variant.Size+pt[1]] = s
+	}
+}
+
+// play plays moves on p in turn and fails the test on the first error.
+func play(t *testing.T, p *Position, moves ...[2]int) {
+	t.Helper()
+	for _, m := range moves {
+		if err := p.Play(m[0], m[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// lineFrom returns n points from start along d.
+func lineFrom(start, d [2]int, n int) [][2]int {
+	line := make([][2]int, n)
+	for k := range line {
+		line[k] = [2]int{start[0] + k*d[0], start[1] + k*d[1]}
+	}
+	return line
+}
+
+func TestPlayErrors(t *testing.T) {
+	won := NewPosition(Standard)
+	place(won, Black, lineFrom([2]int{7, 3}, [2]int{0, 1}, 4)...)
+	play(t, won, [2]int{7, 7})
+
+	swap := NewPosition(Variant{Size: 15, WinLength: 5, Opening: Swap})
+	play(t, swap, [2]int{7, 7}, [2]int{7, 8}, [2]int{8, 7})
+
+	occupied := NewPosition(Standard)
+	play(t, occupied, [2]int{7, 7})
+
+	for _, tc := range []struct {
+		name     string
+		p        *Position
+		row, col int
+		want     error
+	}{
+		{"above the board", NewPosition(Standard), -1, 0, ErrOffBoard},
+		{"below the board", NewPosition(Standard), 15, 0, ErrOffBoard},
+		{"right of the board", NewPosition(Standard), 0, 15, ErrOffBoard},
+		{"on a stone", occupied, 7, 7, ErrOccupied},
+		{"after the game", won, 0, 0, ErrGameOver},
+		{"before a choice", swap, 0, 0, ErrChoiceDue},
+		{"pro away from the centre", NewPosition(Variant{Size: 15, WinLength: 5, Opening: Pro}), 0, 0, ErrOpening},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := tc.p.MoveCount()
+			err := tc.p.Play(tc.row, tc.col)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("Play(%d, %d) = %v, want %v", tc.row, tc.col, err, tc.want)
+			}
+			if tc.p.Legal(tc.row, tc.col) {
+				t.Error("Legal reports the point playable")
+			}
+			if tc.p.MoveCount() != n {
+				t.Error("a refused move was recorded")
+			}
+		})
+	}
+}
+
+func TestWinEveryDirection(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		start, d [2]int
+	}{
+		{"row in the middle", [2]int{7, 5}, [2]int{0, 1}},
+		{"row from the left edge", [2]int{3, 0}, [2]int{0, 1}},
+		{"row to the right edge", [2]int{3, 10}, [2]int{0, 1}},
+		{"column from the top edge", [2]int{0, 4}, [2]int{1, 0}},
+		{"column to the bottom edge", [2]int{10, 4}, [2]int{1, 0}},
+		{"diagonal from the corner", [2]int{0, 0}, [2]int{1, 1}},
+		{"diagonal into the corner", [2]int{10, 10}, [2]int{1, 1}},
+		{"anti-diagonal from the corner", [2]int{0, 14}, [2]int{1, -1}},
+		{"anti-diagonal into the corner", [2]int{10, 4}, [2]int{1, -1}},
+	} {
+		line := lineFrom(tc.start, tc.d, 5)
+		// The winning stone may go on any point of the line.
+		for last := range line {
+			p := NewPosition(Standard)
+			for k, pt := range line {
+				if k != last {
+					place(p, Black, pt)
+				}
+			}
+			play(t, p, line[last])
+			if p.Result() != BlackWins {
+				t.Errorf("%s, last stone %d: result %v, want %v", tc.name, last, p.Result(), BlackWins)
+				continue
+			}
+			if got := p.WinningLine(); !slices.Equal(got, line) {
+				t.Errorf("%s, last stone %d: winning line %v, want %v", tc.name, last, got, line)
+			}
+			if got := p.Ending(); got != "5 in a row" {
+				t.Errorf("%s: ending %q", tc.name, got)
+			}
+		}
+
+		// Four stones are not enough.
+		p := NewPosition(Standard)
+		place(p, Black, line[1:4]...)
+		play(t, p, line[0])
+		if p.Result() != Ongoing {
+			t.Errorf("%s: four in a row gave %v", tc.name, p.Result())
+		}
+	}
+}
+
+func TestCloses(t *testing.T) {
+	for _, tc := range []struct {
+		rule          Rule
+		before, after Stone
+		closes        bool
+	}{
+		{Caro, White, White, true},
+		{Caro, White, Empty, false},
+		{Caro, Empty, White, false},
+		{Caro, Black, White, false},
+		{Caro, Empty, Empty, false},
+		{Freestyle, White, White, false},
+		{Renju, White, White, false},
+	} {
+		if got := tc.rule.Closes(Black, tc.before, tc.after); got != tc.closes {
+			t.Errorf("%v: Closes(Black, %v, %v) = %v", tc.rule, tc.before, tc.after, got)
+		}
+		if got := tc.rule.Closes(White, tc.before.Opponent(), tc.after.Opponent()); got != tc.closes {
+			t.Errorf("%v: Closes for White = %v", tc.rule, got)
+		}
+	}
+}
+
+func TestCaro(t *testing.T) {
+	caro := Variant{Size: 15, WinLength: 5, Rule: Caro}
+	row := [2]int{0, 1}
+	for _, tc := range []struct {
+		name  string
+		black [][2]int // on the board before Black's move
+		white [][2]int // the ends White holds
+		move  [2]int
+		wins  bool
+	}{
+		{"open", lineFrom([2]int{7, 3}, row, 4), nil, [2]int{7, 7}, true},
+		{"closed at one end", lineFrom([2]int{7, 3}, row, 4), [][2]int{{7, 2}}, [2]int{7, 7}, true},
+		{"closed at both ends", lineFrom([2]int{7, 3}, row, 4), [][2]int{{7, 2}, {7, 8}}, [2]int{7, 7}, false},
+		{"at the edge", lineFrom([2]int{7, 0}, row, 4), [][2]int{{7, 5}}, [2]int{7, 4}, true},
+		{"at the other edge", lineFrom([2]int{7, 11}, row, 4), [][2]int{{7, 9}}, [2]int{7, 10}, true},
+		{"overline closed at both ends", lineFrom([2]int{7, 3}, row, 5), [][2]int{{7, 2}, {7, 9}}, [2]int{7, 8}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewPosition(caro)
+			place(p, Black, tc.black...)
+			place(p, White, tc.white...)
+			play(t, p, tc.move)
+			if got := p.Result() == BlackWins; got != tc.wins {
+				t.Fatalf("result %v", p.Result())
+			}
+			line, ends := p.ClosedLine()
+			if tc.wins != (line == nil) {
+				t.Errorf("closed line %v", line)
+			}
+			if line != nil && ends != [2][2]int{tc.white[0], tc.white[1]} {
+				t.Errorf("closed by %v, want %v", ends, tc.white)
+			}
+		})
+	}
+}
+
+func TestResult(t *testing.T) {
+	t.Run("white wins", func(t *testing.T) {
+		p := NewPosition(Standard)
+		for k := 0; k < 4; k++ {
+			play(t, p, [2]int{0, 2 * k}, [2]int{5, k})
+		}
+		if p.Result() != Ongoing {
+			t.Fatalf("result %v before the fifth stone", p.Result())
+		}
+		play(t, p, [2]int{14, 14}, [2]int{5, 4})
+		if p.Result() != WhiteWins || p.Result().Winner() != White {
+			t.Errorf("result %v, want %v", p.Result(), WhiteWins)
+		}
+	})
+
+	t.Run("full board", func(t *testing.T) {
+		// Pairs of colours in every row, shifted by two each row, leave no
+		// five on a 5x5 board.
+		p := NewPosition(Variant{Size: 5, WinLength: 5})
+		var black, white [][2]int
+		for r := 0; r < 5; r++ {
+			for c := 0; c < 5; c++ {
+				if (2*r+c)%4 < 2 {
+					black = append(black, [2]int{r, c})
+				} else {
+					white = append(white, [2]int{r, c})
+				}
+			}
+		}
+		for i := range black {
+			play(t, p, black[i])
+			if i < len(white) {
+				play(t, p, white[i])
+			}
+		}
+		if p.Result() != Draw || p.Ending() != "board full" {
+			t.Errorf("result %v (%s), want a full board draw", p.Result(), p.Ending())
+		}
+	})
+
+	t.Run("resign and agree", func(t *testing.T) {
+		p := NewPosition(Standard)
+		play(t, p, [2]int{7, 7})
+		if err := p.Resign(Black); err != nil {
+			t.Fatal(err)
+		}
+		if p.Result() != WhiteWins || p.Ending() != "Black resigned" {
+			t.Errorf("result %v (%s) after Black resigned", p.Result(), p.Ending())
+		}
+		if err := p.AgreeDraw(); !errors.Is(err, ErrGameOver) {
+			t.Errorf("AgreeDraw after the game = %v", err)
+		}
+		if err := p.Undo(); err != nil || p.Result() != Ongoing || p.MoveCount() != 1 {
+			t.Fatalf("Undo took back %v, leaving %v and %d moves", err, p.Result(), p.MoveCount())
+		}
+		if err := p.AgreeDraw(); err != nil || p.Result() != Draw || p.Ending() != "draw agreed" {
+			t.Errorf("AgreeDraw = %v, result %v (%s)", err, p.Result(), p.Ending())
+		}
+	})
+}
+
+func TestUndo(t *testing.T) {
+	t.Run("moves", func(t *testing.T) {
+		p := NewPosition(Standard)
+		if err := p.Undo(); !errors.Is(err, ErrNoHistory) {
+			t.Fatalf("Undo on an empty board = %v", err)
+		}
+		empty := p.Clone()
+		play(t, p, [2]int{7, 7}, [2]int{7, 8})
+		after := p.Clone()
+		play(t, p, [2]int{8, 8})
+		if err := p.Undo(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(p.cells, after.cells) || p.ToMove() != Black || p.MoveCount() != 2 {
+			t.Errorf("Undo left %v to move after %d moves", p.ToMove(), p.MoveCount())
+		}
+		p.Undo()
+		p.Undo()
+		if !slices.Equal(p.cells, empty.cells) || p.ToMove() != Black {
+			t.Error("undoing every move did not clear the board")
+		}
+	})
+
+	t.Run("a win", func(t *testing.T) {
+		p := NewPosition(Standard)
+		place(p, Black, lineFrom([2]int{7, 3}, [2]int{0, 1}, 4)...)
+		play(t, p, [2]int{7, 7})
+		if err := p.Undo(); err != nil {
+			t.Fatal(err)
+		}
+		if p.Result() != Ongoing || p.WinningLine() != nil || p.At(7, 7) != Empty {
+			t.Errorf("after Undo: result %v, line %v", p.Result(), p.WinningLine())
+		}
+		if !p.Legal(7, 7) {
+			t.Error("the winning point cannot be played again")
+		}
+	})
+
+	t.Run("a capture", func(t *testing.T) {
+		p := NewPosition(Variant{Size: 15, WinLength: 5, Rule: Pente})
+		play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{0, 0}, [2]int{7, 9}, [2]int{7, 10})
+		if p.At(7, 8) != Empty || p.At(7, 9) != Empty || p.Captured(Black) != 2 {
+			t.Fatalf("capture not made: %v %v, %d captured", p.At(7, 8), p.At(7, 9), p.Captured(Black))
+		}
+		if err := p.Undo(); err != nil {
+			t.Fatal(err)
+		}
+		if p.At(7, 8) != White || p.At(7, 9) != White || p.Captured(Black) != 0 || p.ToMove() != Black {
+			t.Errorf("Undo left %v %v, %d captured, %v to move",
+				p.At(7, 8), p.At(7, 9), p.Captured(Black), p.ToMove())
+		}
+	})
+
+	t.Run("a choice", func(t *testing.T) {
+		p := NewPosition(Variant{Size: 15, WinLength: 5, Opening: Swap})
+		play(t, p, [2]int{7, 7}, [2]int{7, 8}, [2]int{8, 7})
+		if err := p.Decide(TakeWhite); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Undo(); err != nil {
+			t.Fatal(err)
+		}
+		if p.MoveCount() != 3 || len(p.Pending()) == 0 {
+			t.Errorf("Undo after a choice left %d moves and %v pending", p.MoveCount(), p.Pending())
+		}
+	})
+}
+
+func TestNext(t *testing.T) {
+	for _, tc := range []struct {
+		rule   Rule
+		s      Stone
+		placed int
+		want   Stone
+	}{
+		{Freestyle, Black, 1, White},
+		{Freestyle, White, 2, Black},
+		{Renju, Black, 3, White},
+		{Connect6, Black, 1, White},
+		{Connect6, White, 2, White},
+		{Connect6, White, 3, Black},
+		{Connect6, Black, 4, Black},
+		{Connect6, Black, 5, White},
+	} {
+		if got := tc.rule.Next(tc.s, tc.placed); got != tc.want {
+			t.Errorf("%v: Next(%v, %d) = %v, want %v", tc.rule, tc.s, tc.placed, got, tc.want)
+		}
+	}
+}
+
+func TestTwoStoneTurns(t *testing.T) {
+	p := NewPosition(Variant{Size: 19, WinLength: 6, Rule: Connect6})
+	for i, want := range []struct {
+		toMove Stone
+		left   int
+	}{
+		{Black, 1},
+		{White, 2},
+		{White, 1},
+		{Black, 2},
+		{Black, 1},
+		{White, 2},
+	} {
+		if p.ToMove() != want.toMove || p.StonesLeft() != want.left {
+			t.Fatalf("after %d stones: %v to move with %d left, want %v with %d",
+				i, p.ToMove(), p.StonesLeft(), want.toMove, want.left)
+		}
+		play(t, p, [2]int{i, 0})
+	}
+
+	// UndoTurn takes back the stones of the last turn placed so far: one of
+	// White's, then two of each, then Black's first.
+	for _, want := range []struct {
+		moves  int
+		toMove Stone
+	}{
+		{5, White},
+		{3, Black},
+		{1, White},
+		{0, Black},
+	} {
+		if err := p.UndoTurn(); err != nil {
+			t.Fatal(err)
+		}
+		if p.MoveCount() != want.moves || p.ToMove() != want.toMove {
+			t.Fatalf("UndoTurn left %d moves and %v to move, want %d and %v",
+				p.MoveCount(), p.ToMove(), want.moves, want.toMove)
+		}
+	}
+	if err := p.UndoTurn(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("UndoTurn on an empty board = %v", err)
+	}
+}
+
+func TestLegal(t *testing.T) {
+	t.Run("renju restricts only black", func(t *testing.T) {
+		// Black's double three at 7,7.
+		p := NewPosition(Variant{Size: 15, WinLength: 5, Rule: Renju})
+		place(p, Black, [2]int{7, 5}, [2]int{7, 6}, [2]int{5, 7}, [2]int{6, 7})
+		if p.Legal(7, 7) || !p.Forbidden(7, 7) {
+			t.Error("black double three allowed")
+		}
+		if err := p.Play(7, 7); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Play = %v, want %v", err, ErrForbidden)
+		}
+		p.toMove = White
+		if !p.Legal(7, 7) {
+			t.Error("white may not play a point forbidden to black")
+		}
+	})
+
+	t.Run("freestyle restricts nothing", func(t *testing.T) {
+		p := NewPosition(Standard)
+		place(p, Black, [2]int{7, 5}, [2]int{7, 6}, [2]int{5, 7}, [2]int{6, 7})
+		if !p.Legal(7, 7) || p.Forbidden(7, 7) {
+			t.Error("freestyle forbids a double three")
+		}
+	})
+
+	t.Run("pro", func(t *testing.T) {
+		p := NewPosition(Variant{Size: 15, WinLength: 5, Opening: Pro})
+		if p.Legal(6, 6) || !p.Legal(7, 7) {
+			t.Fatal("Black's first stone is not held to the centre")
+		}
+		play(t, p, [2]int{7, 7}, [2]int{7, 8})
+		if p.Legal(9, 9) || !p.Legal(4, 9) || !p.Legal(10, 7) {
+			t.Error("Black's second stone is not held three points from the centre")
+		}
+	})
+}
+
+func TestDecide(t *testing.T) {
+	opening := [][2]int{{7, 7}, {7, 8}, {8, 7}}
+
+	for _, tc := range []struct {
+		name    string
+		c       Choice
+		firstIs Stone
+		turn    Side
+	}{
+		{"second takes white", TakeWhite, Black, Second},
+		{"second takes black", TakeBlack, White, First},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewPosition(Variant{Size: 15, WinLength: 5, Opening: Swap})
+			play(t, p, opening...)
+			if p.Turn() != Second || !slices.Equal(p.Pending(), []Choice{TakeBlack, TakeWhite}) {
+				t.Fatalf("%v to choose from %v", p.Turn(), p.Pending())
+			}
+			if err := p.Decide(PlaceTwo); !errors.Is(err, ErrNoChoice) {
+				t.Errorf("Decide(PlaceTwo) under swap = %v", err)
+			}
+			if err := p.Decide(tc.c); err != nil {
+				t.Fatal(err)
+			}
+			if p.StoneOf(First) != tc.firstIs || p.Turn() != tc.turn || p.Pending() != nil {
+				t.Errorf("first player holds %v, %v to act", p.StoneOf(First), p.Turn())
+			}
+			if err := p.Decide(tc.c); !errors.Is(err, ErrNoChoice) {
+				t.Errorf("second Decide = %v", err)
+			}
+		})
+	}
+
+	t.Run("swap2 places two", func(t *testing.T) {
+		p := NewPosition(Variant{Size: 15, WinLength: 5, Opening: Swap2})
+		play(t, p, opening...)
+		if err := p.Decide(PlaceTwo); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range [][2]int{{9, 9}, {3, 3}} {
+			if p.Turn() != Second {
+				t.Fatalf("%v places the extra stones", p.Turn())
+			}
+			play(t, p, m)
+		}
+		if p.Turn() != First || !slices.Equal(p.Pending(), []Choice{TakeBlack, TakeWhite}) {
+			t.Fatalf("%v to choose from %v", p.Turn(), p.Pending())
+		}
+		if err := p.Decide(TakeWhite); err != nil {
+			t.Fatal(err)
+		}
+		// Five stones down, White is to move and the first player holds it.
+		if p.StoneOf(First) != White || p.Turn() != First {
+			t.Errorf("first player holds %v, %v to act", p.StoneOf(First), p.Turn())
+		}
+	})
+}
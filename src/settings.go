@@ -0,0 +1,185 @@
+package src
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+	"wuziqi/utils"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	settingsTop     = 120
+	settingsSpacing = 30
+)
+
+// hardEngines are the engines Hard can be played with. The MCTS and
+// alpha-beta presets stand in when the AlphaZero model or Python are not
+// wanted.
+var hardEngines = []string{"alphazero", "mcts-hard", "alphabeta-hard"}
+
+var playoutSteps = []int{0, 500, 1000, 2000, 5000, 10000, 20000, 50000}
+
+var workerSteps = []int{0, 1, 2, 4, 8, 16}
+
+func (g *Game) settingsLines() []string {
+	p := g.customProfile
+	playouts := "unlimited"
+	if p.Playouts > 0 {
+		playouts = fmt.Sprint(p.Playouts)
+	}
+	order := "random"
+	if p.PatternOrder {
+		order = "pattern"
+	}
+	workers := "all CPUs"
+	if p.Workers > 0 {
+		workers = fmt.Sprint(p.Workers)
+	}
+	return []string{
+		"Hard engine: " + g.hardEngine,
+		"Custom time limit: " + p.TimeLimit.String(),
+		"Custom playouts: " + playouts,
+		fmt.Sprintf("Custom exploration: %.2f", p.Exploration),
+		"Custom rollouts: " + p.Rollout.String(),
+		"Custom move order: " + order,
+		"Custom threat check: " + onOff(p.ThreatCheck),
+		"Custom workers: " + workers,
+	}
+}
+
+func (g *Game) updateSettings() {
+	if step := selectOption(&g.settingsIdx, len(g.settingsLines())); step != 0 {
+		g.adjustSetting(g.settingsIdx, step)
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.state = StateDifficultySelect
+	}
+}
+
+// selectOption moves *idx through a list of n options drawn by
+// drawOptions, by arrow keys or a click, and returns the step the left and
+// right arrows ask for.
+func selectOption(idx *int, n int) int {
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		*idx = (*idx + n - 1) % n
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		*idx = (*idx + 1) % n
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		_, y := ebiten.CursorPosition()
+		if i := (y - settingsTop + settingsSpacing*2/3) / settingsSpacing; y >= settingsTop-settingsSpacing*2/3 && i < n {
+			*idx = i
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+		return 1
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		return -1
+	}
+	return 0
+}
+
+func (g *Game) adjustSetting(idx, step int) {
+	p := &g.customProfile
+	switch idx {
+	case 0:
+		g.hardEngine = hardEngines[(indexOf(hardEngines, g.hardEngine)+step+len(hardEngines))%len(hardEngines)]
+	case 1:
+		p.TimeLimit += time.Duration(step) * 500 * time.Millisecond
+		if p.TimeLimit < 500*time.Millisecond {
+			p.TimeLimit = 500 * time.Millisecond
+		}
+		if p.TimeLimit > 30*time.Second {
+			p.TimeLimit = 30 * time.Second
+		}
+	case 2:
+		p.Playouts = stepThrough(playoutSteps, p.Playouts, step)
+	case 3:
+		p.Exploration += float64(step) * 0.1
+		if p.Exploration < 0.1 {
+			p.Exploration = 0.1
+		}
+		if p.Exploration > 3 {
+			p.Exploration = 3
+		}
+	case 4:
+		p.Rollout = (p.Rollout + RolloutPolicy(step) + rolloutPolicyCount) % rolloutPolicyCount
+	case 5:
+		p.PatternOrder = !p.PatternOrder
+	case 6:
+		p.ThreatCheck = !p.ThreatCheck
+	case 7:
+		p.Workers = stepThrough(workerSteps, p.Workers, step)
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// stepThrough moves step places from cur along the ascending list steps.
+func stepThrough(steps []int, cur, step int) int {
+	i := 0
+	for i < len(steps)-1 && steps[i] < cur {
+		i++
+	}
+	i += step
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(steps) {
+		i = len(steps) - 1
+	}
+	return steps[i]
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return 0
+}
+
+func (g *Game) drawSettings(screen *ebiten.Image) {
+	drawOptions(screen, "Settings", g.settingsLines(), g.settingsIdx, "ESC: Back")
+}
+
+// drawOptions draws a titled list of options with the selected one marked,
+// and the keys that work on it.
+func drawOptions(screen *ebiten.Image, title string, lines []string, selected int, keys string) {
+	tw := text.BoundString(utils.MplusFont, title).Dx()
+	text.Draw(screen, title, utils.MplusFont, (WindowWidth-tw)/2, 80, color.White)
+
+	scale := 0.6
+	drawScaledText := func(s string, x, y int, clr color.Color) {
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(scale, scale)
+		op.GeoM.Translate(float64(x), float64(y))
+		op.ColorM.ScaleWithColor(clr)
+		text.DrawWithOptions(screen, s, utils.MplusFont, op)
+	}
+
+	for i, line := range lines {
+		var col color.Color = color.White
+		if i == selected {
+			col = color.RGBA{200, 255, 200, 255}
+			line = "> " + line
+		}
+		drawScaledText(line, 30, settingsTop+i*settingsSpacing, col)
+	}
+
+	drawScaledText("Up/Down: select  Left/Right: change", 30, WindowHeight-60, color.Gray{150})
+	drawScaledText(keys, 30, WindowHeight-30, color.Gray{150})
+}
@@ -0,0 +1,339 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wuziqi/src/rules"
+)
+
+// ThreatKind selects the attacking moves a threat search may use.
+type ThreatKind int
+
+const (
+	// VCF wins by continuous fours: every attacking move threatens five.
+	VCF ThreatKind = iota
+	// VCT wins by continuous threats: fours and threes that threaten an
+	// open four.
+	VCT
+)
+
+func (k ThreatKind) String() string {
+	if k == VCT {
+		return "VCT"
+	}
+	return "VCF"
+}
+
+// ThreatLimits bounds a threat search. Depth counts attacking moves.
+type ThreatLimits struct {
+	Nodes     int
+	TimeLimit time.Duration
+	Depth     int
+}
+
+// DefaultThreatLimits is the budget the engines spend looking for a forced
+// win before they search.
+var DefaultThreatLimits = ThreatLimits{Nodes: 20000, TimeLimit: 300 * time.Millisecond, Depth: 8}
+
+// ThreatResult is the outcome of a threat search. Without a win, Complete
+// means no forced win of the kind exists within Depth; otherwise a limit
+// cut the search short and the question is open.
+type ThreatResult struct {
+	Win      bool
+	Line     [][2]int // the attacker's moves alternating with the defence
+	Nodes    int
+	Complete bool
+}
+
+// SolveThreats looks for a forced win for attacker, who is to move on
+// board. VCT iterates on the depth, so the line found is the shortest.
+func SolveThreats(ctx context.Context, board Board, attacker Stone, kind ThreatKind, limits ThreatLimits) ThreatResult {
+	t := &threatSearch{
+		ctx:      ctx,
+		attacker: attacker,
+		vct:      kind == VCT,
+		limits:   limits,
+		deadline: time.Now().Add(limits.TimeLimit),
+	}
+	first := limits.Depth
+	if t.vct {
+		first = 1
+	}
+	var res ThreatResult
+	for depth := first; depth <= limits.Depth; depth++ {
+		var line [][2]int
+		if t.attack(&board, depth, &line) {
+			return ThreatResult{Win: true, Line: line, Nodes: t.nodes, Complete: true}
+		}
+		res = ThreatResult{Nodes: t.nodes, Complete: !t.stopped}
+		if t.stopped {
+			break
+		}
+	}
+	return res
+}
+
+// Describe summarises res, the answer ForcedWin gave for side using kind.
+func (res ThreatResult) Describe(side Stone, kind ThreatKind) string {
+	switch {
+	case res.Win:
+		return fmt.Sprintf("%v wins by %v from %d,%d", side, kind, res.Line[0][0], res.Line[0][1])
+	case res.Complete:
+		return fmt.Sprintf("No forced win for %v", side)
+	}
+	return fmt.Sprintf("No forced win found for %v (search limit)", side)
+}
+
+// threatsApply reports whether threats decide games under rule. They do
+// not when a capture can break the line, or when two stones a turn answer
+// a four and make one.
+func threatsApply(rule rules.Rule) bool {
+	return rule.CaptureGoal() == 0 && rule.StonesPerTurn() == 1
+}
+
+// ForcedWin runs VCF and then VCT for the side to move within limits, and
+// returns the first win found. Where threats do not apply it finds nothing.
+func ForcedWin(ctx context.Context, board Board, toMove Stone, limits ThreatLimits) (ThreatResult, ThreatKind) {
+	if !threatsApply(board.Rule) {
+		return ThreatResult{}, VCF
+	}
+	res := SolveThreats(ctx, board, toMove, VCF, limits)
+	if res.Win || ctx.Err() != nil {
+		return res, VCF
+	}
+	return SolveThreats(ctx, board, toMove, VCT, limits), VCT
+}
+
+type threatSearch struct {
+	ctx      context.Context
+	attacker Stone
+	vct      bool
+	limits   ThreatLimits
+	deadline time.Time
+	nodes    int
+	stopped  bool
+}
+
+func (t *threatSearch) tick() bool {
+	t.nodes++
+	if t.limits.Nodes > 0 && t.nodes > t.limits.Nodes {
+		t.stopped = true
+	}
+	if t.nodes&255 == 0 && (!time.Now().Before(t.deadline) || t.ctx.Err() != nil) {
+		t.stopped = true
+	}
+	return !t.stopped
+}
+
+// attack reports whether the attacker, to move, wins by force using at
+// most depth more threats.
+func (t *threatSearch) attack(b *Board, depth int, line *[][2]int) bool {
+	if !t.tick() {
+		return false
+	}
+	att, def := t.attacker, 3-t.attacker
+	if fives := fivePoints(b, att); len(fives) > 0 {
+		*line = [][2]int{fives[0]}
+		return true
+	}
+	if depth == 0 {
+		return false
+	}
+	candidates := searchMoves(b)
+	if blocks := fivePoints(b, def); len(blocks) > 1 {
+		return false
+	} else if len(blocks) == 1 {
+		// The attacker has to block, and keeps the initiative only if the
+		// block is itself a threat.
+		candidates = blocks
+	}
+	for _, m := range candidates {
+		if !isFour(b, m[0], m[1], att) && !(t.vct && isThree(b, m[0], m[1], att)) || !b.Legal(m[0], m[1], att) {
+			continue
+		}
+		b.Set(m[0], m[1], att)
+		var sub [][2]int
+		won := t.defend(b, depth-1, &sub)
+		b.Set(m[0], m[1], Empty)
+		if won {
+			*line = append([][2]int{m}, sub...)
+			return true
+		}
+		if t.stopped {
+			return false
+		}
+	}
+	return false
+}
+
+// defend reports whether every defence against the attacker's last threat
+// still loses.
+func (t *threatSearch) defend(b *Board, depth int, line *[][2]int) bool {
+	if !t.tick() {
+		return false
+	}
+	att, def := t.attacker, 3-t.attacker
+	if len(fivePoints(b, def)) > 0 {
+		return false
+	}
+	var replies [][2]int
+	if fives := fivePoints(b, att); len(fives) > 0 {
+		replies = legalOnly(b, fives, def)
+	} else {
+		replies = t.threeDefences(b)
+	}
+	if len(replies) == 0 {
+		// Nothing stops the threat, or the rule forbids the defender the
+		// blocks, so any reply will do.
+		if moves := legalOnly(b, searchMoves(b), def); len(moves) > 0 {
+			replies = moves[:1]
+		}
+	}
+	for i, r := range replies {
+		b.Set(r[0], r[1], def)
+		var sub [][2]int
+		won := t.attack(b, depth, &sub)
+		b.Set(r[0], r[1], Empty)
+		if !won {
+			return false
+		}
+		if i == 0 {
+			*line = append([][2]int{r}, sub...)
+		}
+	}
+	return len(replies) > 0
+}
+
+// threeDefences lists the defender's answers to a three: the points that
+// leave the attacker no open four, and the defender's own fours. A stone
+// that stops an open four lies on one of its lines, so only those points
+// are tried.
+func (t *threatSearch) threeDefences(b *Board) [][2]int {
+	att, def := t.attacker, 3-t.attacker
+	var openFours [][2]int
+	for _, m := range searchMoves(b) {
+		if isOpenFour(b, m[0], m[1], att) {
+			openFours = append(openFours, m)
+		}
+	}
+
+	var defences [][2]int
+	seen := map[[2]int]bool{}
+	for _, m := range searchMoves(b) {
+		if isFour(b, m[0], m[1], def) {
+			defences = append(defences, m)
+			seen[m] = true
+		}
+	}
+	for _, f := range openFours {
+		for _, d := range lineDirs {
+			for step := 1 - b.WinLength; step < b.WinLength; step++ {
+				m := [2]int{f[0] + step*d[0], f[1] + step*d[1]}
+				if seen[m] || !b.OnBoard(m[0], m[1]) || b.At(m[0], m[1]) != Empty {
+					continue
+				}
+				seen[m] = true
+				b.Set(m[0], m[1], def)
+				stopped := true
+				for _, g := range openFours {
+					if b.At(g[0], g[1]) == Empty && isOpenFour(b, g[0], g[1], att) {
+						stopped = false
+						break
+					}
+				}
+				b.Set(m[0], m[1], Empty)
+				if stopped {
+					defences = append(defences, m)
+				}
+			}
+		}
+	}
+	return legalOnly(b, defences, def)
+}
+
+// fivePoints lists the empty points where p would complete a five, or
+// whatever line length wins on b.
+func fivePoints(b *Board, p Stone) [][2]int {
+	var points [][2]int
+	for r := 0; r < b.Size; r++ {
+		for c := 0; c < b.Size; c++ {
+			if b.At(r, c) == Empty && makesWin(b, r, c, p) {
+				points = append(points, [2]int{r, c})
+			}
+		}
+	}
+	return points
+}
+
+// fivesThrough counts the empty points on the lines through row, col where
+// p would complete a five. Only these can be new after p plays row, col.
+func fivesThrough(b *Board, row, col int, p Stone) int {
+	n := 0
+	for _, d := range lineDirs {
+		for step := 1 - b.WinLength; step < b.WinLength; step++ {
+			r, c := row+step*d[0], col+step*d[1]
+			if step != 0 && b.OnBoard(r, c) && b.At(r, c) == Empty && makesWin(b, r, c, p) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// isFour reports whether p playing the empty point row, col threatens a
+// five.
+func isFour(b *Board, row, col int, p Stone) bool {
+	b.Set(row, col, p)
+	n := fivesThrough(b, row, col, p)
+	b.Set(row, col, Empty)
+	return n > 0
+}
+
+// isOpenFour reports whether p playing the empty point row, col makes two
+// fives at once, which one move cannot block.
+func isOpenFour(b *Board, row, col int, p Stone) bool {
+	b.Set(row, col, p)
+	n := fivesThrough(b, row, col, p)
+	b.Set(row, col, Empty)
+	return n > 1
+}
+
+// isThree reports whether p playing the empty point row, col threatens an
+// open four in one of the lines through it.
+func isThree(b *Board, row, col int, p Stone) bool {
+	b.Set(row, col, p)
+	defer b.Set(row, col, Empty)
+	for _, d := range lineDirs {
+		for step := 1 - b.WinLength; step < b.WinLength; step++ {
+			r, c := row+step*d[0], col+step*d[1]
+			if step == 0 || !b.OnBoard(r, c) || b.At(r, c) != Empty {
+				continue
+			}
+			b.Set(r, c, p)
+			n := fivesAlong(b, r, c, d, p)
+			b.Set(r, c, Empty)
+			if n > 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fivesAlong counts the empty points on the line through row, col along d
+// where p would complete a five in that line.
+func fivesAlong(b *Board, row, col int, d [2]int, p Stone) int {
+	n := 0
+	for step := 1 - b.WinLength; step < b.WinLength; step++ {
+		r, c := row+step*d[0], col+step*d[1]
+		if step == 0 || !b.OnBoard(r, c) || b.At(r, c) != Empty {
+			continue
+		}
+		if winsAlong(b, r, c, d, p) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package src
+
+import "math/rand"
+
+// Zobrist keys: one random number per stone on each point, one for White
+// to move, one per board size, line length and rule so that tables kept
+// between games never mix variants, and one per count of captured stones.
+// A position's hash is the XOR of the keys of its stones, variant and
+// captures. Placing or removing a stone updates it with a single XOR; a
+// capture also takes off the captured stones' keys and swaps the key of
+// the capture count, as playHashed does.
+var (
+	zobristStones  [3][MaxBoardSize][MaxBoardSize]uint64
+	zobristWhite   uint64
+	zobristSizes   [MaxBoardSize + 1]uint64
+	zobristLengths [MaxBoardSize + 1]uint64
+	zobristRules   [8]uint64
+	// zobristCaptured is indexed by colour and stones captured. Games end
+	// at the capture goal, but a search may play on past it, so capturedKey
+	// gives every count from the last key up that one key.
+	zobristCaptured [3][64]uint64
+)
+
+func init() {
+	// A fixed seed keeps hashes the same from run to run.
+	r := rand.New(rand.NewSource(0x5eed))
+	for _, s := range []Stone{Black, White} {
+		for row := 0; row < MaxBoardSize; row++ {
+			for col := 0; col < MaxBoardSize; col++ {
+				zobristStones[s][row][col] = r.Uint64()
+			}
+		}
+	}
+	zobristWhite = r.Uint64()
+	for i := range zobristSizes {
+		zobristSizes[i] = r.Uint64()
+		zobristLengths[i] = r.Uint64()
+	}
+	for i := range zobristRules {
+		zobristRules[i] = r.Uint64()
+	}
+	for _, s := range []Stone{Black, White} {
+		for i := range zobristCaptured[s] {
+			zobristCaptured[s][i] = r.Uint64()
+		}
+	}
+}
+
+// Hash returns the Zobrist hash of the stones and captures on b.
+func (b *Board) Hash() uint64 {
+	h := zobristSizes[b.Size] ^ zobristLengths[b.WinLength] ^ zobristRules[b.Rule]
+	h ^= capturedKey(Black, b.captured[Black]) ^ capturedKey(White, b.captured[White])
+	for row := 0; row < b.Size; row++ {
+		for col := 0; col < b.Size; col++ {
+			if s := b.At(row, col); s != Empty {
+				h ^= zobristStones[s][row][col]
+			}
+		}
+	}
+	return h
+}
+
+// toggleStone updates hash h for s being placed on or removed from row, col.
+func toggleStone(h uint64, row, col int, s Stone) uint64 {
+	return h ^ zobristStones[s][row][col]
+}
+
+// capturedKey is the key of s having captured n stones. No captures have
+// no key, so games without them hash as they always did.
+func capturedKey(s Stone, n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	return zobristCaptured[s][min(n, len(zobristCaptured[s])-1)]
+}
+
+// playHashed plays s on row, col like Play and returns h, the hash of b
+// before the move, updated for the stone, the stones it captured and the
+// new count of captures.
+func (b *Board) playHashed(h uint64, row, col int, s Stone) uint64 {
+	before := b.captured[s]
+	taken := b.Play(row, col, s)
+	h = toggleStone(h, row, col, s)
+	for _, t := range taken {
+		h = toggleStone(h, t[0], t[1], 3-s)
+	}
+	return h ^ capturedKey(s, before) ^ capturedKey(s, b.captured[s])
+}
+
+// positionKey combines a board hash with the side to move.
+func positionKey(h uint64, toMove Stone) uint64 {
+	if toMove == White {
+		return h ^ zobristWhite
+	}
+	return h
+}
+
+// TransTable is a bounded table from position keys to values. Each key has
+// one slot, and a new entry simply replaces whatever shared its slot, so
+// the table never grows past the size it was made with.
+type TransTable[V any] struct {
+	slots []ttSlot[V]
+	mask  uint64
+}
+
+type ttSlot[V any] struct {
+	key  uint64
+	used bool
+	val  V
+}
+
+// NewTransTable makes a table of at most size entries, rounded down to a
+// power of two.
+func NewTransTable[V any](size int) *TransTable[V] {
+	n := 1
+	for n*2 <= size {
+		n *= 2
+	}
+	return &TransTable[V]{slots: make([]ttSlot[V], n), mask: uint64(n - 1)}
+}
+
+func (t *TransTable[V]) Get(key uint64) (V, bool) {
+	s := &t.slots[key&t.mask]
+	if s.used && s.key == key {
+		return s.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (t *TransTable[V]) Put(key uint64, val V) {
+	t.slots[key&t.mask] = ttSlot[V]{key: key, used: true, val: val}
+}
+
+// Delete removes key if it is still in the table.
+func (t *TransTable[V]) Delete(key uint64) {
+	s := &t.slots[key&t.mask]
+	if s.used && s.key == key {
+		*s = ttSlot[V]{}
+	}
+}
+
+func (t *TransTable[V]) Clear() {
+	clear(t.slots)
+}
@@ -0,0 +1,129 @@
+package src
+
+import (
+	"math/rand"
+	"testing"
+
+	"wuziqi/src/rules"
+)
+
+func TestHashThroughPlayAndUndo(t *testing.T) {
+	for _, v := range []rules.Variant{
+		rules.Standard,
+		{Size: 9, WinLength: 5, Rule: rules.Pente},
+		{Size: 9, WinLength: 5, Rule: rules.Keryo},
+	} {
+		t.Run(v.String(), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			captures := 0
+			for game := 0; game < 50; game++ {
+				b := NewBoard(v)
+				start := b.Hash()
+				h := start
+				var undo []Board // the board before each move
+				for step := 0; step < 120; step++ {
+					if len(undo) > 0 && rng.Intn(4) == 0 {
+						// Taking the move back toggles the same keys again.
+						before := undo[len(undo)-1]
+						undo = undo[:len(undo)-1]
+						h = rehash(h, &b, &before)
+						b = before
+					} else {
+						row, col := rng.Intn(v.Size), rng.Intn(v.Size)
+						if b.At(row, col) != Empty {
+							continue
+						}
+						s := Stone(1 + rng.Intn(2))
+						undo = append(undo, b)
+						h = b.playHashed(h, row, col, s)
+						if b.captured != undo[len(undo)-1].captured {
+							captures++
+						}
+					}
+					if want := b.Hash(); h != want {
+						t.Fatalf("game %d step %d: incremental hash %x, from scratch %x", game, step, h, want)
+					}
+				}
+				for len(undo) > 0 {
+					before := undo[len(undo)-1]
+					undo = undo[:len(undo)-1]
+					h = rehash(h, &b, &before)
+					b = before
+				}
+				if h != start || b.Stones() != 0 {
+					t.Fatalf("game %d: hash %x after taking every move back, want %x", game, h, start)
+				}
+			}
+			if v.Rule.CaptureGoal() > 0 && captures == 0 {
+				t.Error("no game captured anything")
+			}
+		})
+	}
+}
+
+// rehash returns h, the hash of b, updated to that of the board to, one
+// move away, point by point as the searches do.
+func rehash(h uint64, b, to *Board) uint64 {
+	for row := 0; row < b.Size; row++ {
+		for col := 0; col < b.Size; col++ {
+			if s := b.At(row, col); s != to.At(row, col) {
+				if s != Empty {
+					h = toggleStone(h, row, col, s)
+				}
+				if s := to.At(row, col); s != Empty {
+					h = toggleStone(h, row, col, s)
+				}
+			}
+		}
+	}
+	for _, s := range []Stone{Black, White} {
+		h ^= capturedKey(s, b.captured[s]) ^ capturedKey(s, to.captured[s])
+	}
+	return h
+}
+
+func TestHashSeparatesVariantsAndSides(t *testing.T) {
+	a, b := NewBoard(rules.Standard), NewBoard(rules.Variant{Size: 15, WinLength: 5, Rule: rules.Renju})
+	if a.Hash() == b.Hash() {
+		t.Error("freestyle and renju boards hash the same")
+	}
+	a.Set(7, 7, Black)
+	if positionKey(a.Hash(), Black) == positionKey(a.Hash(), White) {
+		t.Error("the side to move does not change the key")
+	}
+}
+
+func TestTransTable(t *testing.T) {
+	tt := NewTransTable[int](100) // rounded down to 64 slots
+	if len(tt.slots) != 64 {
+		t.Fatalf("%d slots, want 64", len(tt.slots))
+	}
+	tt.Put(3, 30)
+	if v, ok := tt.Get(3); !ok || v != 30 {
+		t.Errorf("Get(3) = %v, %v", v, ok)
+	}
+	// 67 shares the slot of 3 and takes it over.
+	tt.Put(67, 670)
+	if _, ok := tt.Get(3); ok {
+		t.Error("3 survived a key sharing its slot")
+	}
+	tt.Delete(3) // no longer there, so 67 stays
+	if v, ok := tt.Get(67); !ok || v != 670 {
+		t.Errorf("Get(67) = %v, %v", v, ok)
+	}
+	tt.Delete(67)
+	tt.Put(5, 50)
+	tt.Clear()
+	if _, ok := tt.Get(5); ok {
+		t.Error("Clear left an entry")
+	}
+}
+
+func TestHashPastTheCaptureKeys(t *testing.T) {
+	b := NewBoard(rules.Variant{Size: 15, WinLength: 5, Rule: rules.Keryo})
+	b.captured[White] = len(zobristCaptured[White]) + 10
+	empty := NewBoard(b.Variant())
+	if b.Hash() == empty.Hash() {
+		t.Error("captures past the last key do not change the hash")
+	}
+}